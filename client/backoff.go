@@ -6,11 +6,11 @@ import (
 	"time"
 )
 
-// BackoffFunc 重试补偿 方法
+// BackoffFunc 重试补偿 方法 attempts 为重试次数 从1开始
 type BackoffFunc func(ctx context.Context, req *Request, attempts int) (time.Duration, error)
 
 func exponentialBackoff(ctx context.Context, req *Request, attempts int) (time.Duration, error) {
-	return do(attempts), nil
+	return withRetryAfter(req, do(attempts)), nil
 }
 
 func do(attempts int) time.Duration {
@@ -19,3 +19,54 @@ func do(attempts int) time.Duration {
 	}
 	return time.Duration(math.Pow(float64(attempts), math.E)) * time.Millisecond * 100
 }
+
+// ConstantBackoff waits d before every retry.
+func ConstantBackoff(d time.Duration) BackoffFunc {
+	return func(ctx context.Context, req *Request, attempts int) (time.Duration, error) {
+		return withRetryAfter(req, d), nil
+	}
+}
+
+// ExponentialJitterBackoff waits a random duration in [0, min(max, base*2^attempts)), known as full jitter.
+func ExponentialJitterBackoff(base, max time.Duration) BackoffFunc {
+	return func(ctx context.Context, req *Request, attempts int) (time.Duration, error) {
+		ceil := max
+		if attempts < 62 && base < max>>uint(attempts) {
+			ceil = base << uint(attempts)
+		}
+		return withRetryAfter(req, randDuration(0, ceil)), nil
+	}
+}
+
+// DecorrelatedJitterBackoff waits a random duration in [base, previous*3), capped by max.
+func DecorrelatedJitterBackoff(base, max time.Duration) BackoffFunc {
+	return func(ctx context.Context, req *Request, attempts int) (time.Duration, error) {
+		prev := req.lastBackoff
+		if prev < base {
+			prev = base
+		}
+		t := randDuration(base, prev*3)
+		if t > max {
+			t = max
+		}
+		return withRetryAfter(req, t), nil
+	}
+}
+
+// withRetryAfter prefers upstream Retry-After when it is longer than d, capped by MaxRetryAfter.
+func withRetryAfter(req *Request, d time.Duration) time.Duration {
+	if req == nil || req.opts.maxRetryAfter <= 0 {
+		return d
+	}
+	after, ok := req.LastResponse().RetryAfter()
+	if !ok || after <= d {
+		return d
+	}
+	if after > req.opts.maxRetryAfter {
+		after = req.opts.maxRetryAfter
+	}
+	if after < d {
+		return d
+	}
+	return after
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExponentialJitterBackoff(t *testing.T) {
+	fn := ExponentialJitterBackoff(10*time.Millisecond, 100*time.Millisecond)
+	req := &Request{}
+	for attempts := 1; attempts < 70; attempts++ {
+		d, _ := fn(context.Background(), req, attempts)
+		if d < 0 || d >= 100*time.Millisecond {
+			t.Fatalf("attempts %d backoff %s out of range", attempts, d)
+		}
+	}
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	fn := DecorrelatedJitterBackoff(10*time.Millisecond, 50*time.Millisecond)
+	req := &Request{}
+	for attempts := 1; attempts < 20; attempts++ {
+		d, _ := fn(context.Background(), req, attempts)
+		if d < 10*time.Millisecond || d > 50*time.Millisecond {
+			t.Fatalf("attempts %d backoff %s out of range", attempts, d)
+		}
+		req.lastBackoff = d
+	}
+}
+
+func TestBackoff_RetryAfter(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	resp, err := NewClient(
+		Retries(2),
+		Retry(RetryOnIdempotent),
+		Backoff(ConstantBackoff(time.Millisecond)),
+	).Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatusCode() != http.StatusOK {
+		t.Fatalf("expect retried response, got %d", resp.GetStatusCode())
+	}
+	if cost := time.Since(start); cost < time.Second {
+		t.Fatalf("Retry-After not honoured, cost %s", cost)
+	}
+
+	// 上游的 Retry-After 不超过 MaxRetryAfter
+	atomic.StoreInt32(&hits, 0)
+	start = time.Now()
+	NewClient(Retries(2), Retry(RetryOnIdempotent), Backoff(ConstantBackoff(time.Millisecond)), MaxRetryAfter(10*time.Millisecond)).Get(ts.URL)
+	if cost := time.Since(start); cost > 500*time.Millisecond || atomic.LoadInt32(&hits) != 2 {
+		t.Fatalf("Retry-After should be capped, cost %s hits %d", cost, hits)
+	}
+}
+
+func TestRetryOnIdempotent(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	cli := NewClient(Retries(3), Retry(RetryOnIdempotent), Backoff(ConstantBackoff(0)))
+
+	cli.Post(ts.URL, Options{JSON: map[string]string{"k": "v"}})
+	if n := atomic.SwapInt32(&hits, 0); n != 1 {
+		t.Fatalf("post without idempotency key should not retry, hits: %d", n)
+	}
+
+	cli.Post(ts.URL, Options{
+		JSON:    map[string]string{"k": "v"},
+		Headers: map[string]interface{}{HeaderIdempotencyKey: "order-1"},
+	})
+	if n := atomic.SwapInt32(&hits, 0); n != 3 {
+		t.Fatalf("post with idempotency key should retry, hits: %d", n)
+	}
+}
+
+func TestRetryOnIdempotent_LocalError(t *testing.T) {
+	req := NewClient()
+	req.req, _ = http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if retry, _ := RetryOnIdempotent(context.Background(), req, nil, 0, errors.New("sign failed")); retry {
+		t.Fatal("local error should not be retried")
+	}
+	uerr := &url.Error{Op: "Get", URL: "http://example.com", Err: errors.New("connection refused")}
+	if retry, _ := RetryOnIdempotent(context.Background(), req, nil, 0, uerr); !retry {
+		t.Fatal("transport error should be retried")
+	}
+}
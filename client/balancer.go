@@ -0,0 +1,387 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	urlpkg "net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yulecd/pp-common/plog"
+)
+
+const (
+	BalanceRoundRobin     = "round_robin"
+	BalanceWeightedRandom = "weighted_random"
+	BalanceLeastInFlight  = "least_inflight"
+)
+
+// EndpointConfig 服务的一个节点
+type EndpointConfig struct {
+	URI    string `json:"uri" yaml:"uri"`       // 节点地址 同 base_uri
+	Weight int    `json:"weight" yaml:"weight"` // 权重 默认1
+}
+
+// LoadBalanceConfig 负载均衡配置
+type LoadBalanceConfig struct {
+	Balancer    string             `json:"balancer" yaml:"balancer"`         // round_robin weighted_random least_inflight 默认 round_robin
+	Outlier     *OutlierConfig     `json:"outlier" yaml:"outlier"`           // 被动剔除 不配置使用默认值
+	HealthCheck *HealthCheckConfig `json:"health_check" yaml:"health_check"` // 主动探测 不配置则不探测
+}
+
+// OutlierConfig 连续失败后暂时剔除节点
+type OutlierConfig struct {
+	ConsecutiveFailures int           `json:"consecutive_failures" yaml:"consecutive_failures"` // 连续失败次数 错误或5xx
+	EjectionTime        time.Duration `json:"ejection_time" yaml:"ejection_time"`               // 剔除时间
+}
+
+func (c *OutlierConfig) checkConf() {
+	if c.ConsecutiveFailures <= 0 {
+		c.ConsecutiveFailures = 5
+	}
+	if c.EjectionTime <= 0 {
+		c.EjectionTime = 30 * time.Second
+	}
+}
+
+// HealthCheckConfig 主动健康探测 GET uri+path 返回2xx为健康
+type HealthCheckConfig struct {
+	Path               string        `json:"path" yaml:"path"`
+	Interval           time.Duration `json:"interval" yaml:"interval"`
+	Timeout            time.Duration `json:"timeout" yaml:"timeout"`
+	UnhealthyThreshold int           `json:"unhealthy_threshold" yaml:"unhealthy_threshold"` // 连续失败次数后标记为不健康
+	HealthyThreshold   int           `json:"healthy_threshold" yaml:"healthy_threshold"`     // 连续成功次数后恢复
+}
+
+func (c *HealthCheckConfig) checkConf() {
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.UnhealthyThreshold <= 0 {
+		c.UnhealthyThreshold = 2
+	}
+	if c.HealthyThreshold <= 0 {
+		c.HealthyThreshold = 1
+	}
+}
+
+func (c *LoadBalanceConfig) checkConf() {
+	switch c.Balancer {
+	case BalanceRoundRobin, BalanceWeightedRandom, BalanceLeastInFlight:
+	default:
+		c.Balancer = BalanceRoundRobin
+	}
+	if c.Outlier == nil {
+		c.Outlier = &OutlierConfig{}
+	}
+	c.Outlier.checkConf()
+	if c.HealthCheck != nil {
+		c.HealthCheck.checkConf()
+	}
+}
+
+// Endpoint 节点状态
+type Endpoint struct {
+	URI    string
+	Weight int
+
+	base     *urlpkg.URL
+	inflight int64
+	current  int // 平滑加权轮询的当前权重 由 EndpointPool.rrMu 保护
+
+	mu           sync.Mutex
+	failures     int
+	ejectedUntil time.Time
+	unhealthy    bool
+	probeFails   int
+	probeOks     int
+}
+
+// InFlight returns the number of in-flight requests of the endpoint.
+func (e *Endpoint) InFlight() int64 {
+	return atomic.LoadInt64(&e.inflight)
+}
+
+// Available reports whether the endpoint is neither ejected nor unhealthy.
+func (e *Endpoint) Available() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.available(time.Now())
+}
+
+func (e *Endpoint) available(now time.Time) bool {
+	return !e.unhealthy && !now.Before(e.ejectedUntil)
+}
+
+// EndpointPool 一个服务的多个节点 按策略选择节点
+type EndpointPool struct {
+	conf      LoadBalanceConfig
+	endpoints []*Endpoint
+	rrMu      sync.Mutex
+	transport http.RoundTripper
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// EndpointPoolOption 节点池的可选配置
+type EndpointPoolOption func(p *EndpointPool)
+
+// ProbeTransport sets the transport of health check probes, it should be the transport of the service
+// so that probes use the same tls and proxy settings. DefaultTransport is used if not set.
+func ProbeTransport(rt http.RoundTripper) EndpointPoolOption {
+	return func(p *EndpointPool) {
+		if rt != nil {
+			p.transport = rt
+		}
+	}
+}
+
+// NewEndpointPool instances an endpoint pool, active health check starts when configured.
+func NewEndpointPool(endpoints []EndpointConfig, conf LoadBalanceConfig, opts ...EndpointPoolOption) (*EndpointPool, error) {
+	if err := validateEndpoints(endpoints); err != nil {
+		return nil, err
+	}
+	conf.checkConf()
+
+	p := &EndpointPool{
+		conf:      conf,
+		transport: DefaultTransport,
+		stop:      make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	for _, ec := range endpoints {
+		base, _ := urlpkg.Parse(ec.URI)
+		weight := ec.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		p.endpoints = append(p.endpoints, &Endpoint{
+			URI:    ec.URI,
+			Weight: weight,
+			base:   base,
+		})
+	}
+
+	if conf.HealthCheck != nil {
+		go p.healthCheck()
+	}
+
+	return p, nil
+}
+
+// Endpoints returns all endpoints of the pool.
+func (p *EndpointPool) Endpoints() []*Endpoint {
+	return p.endpoints
+}
+
+// Close stops active health check.
+func (p *EndpointPool) Close() error {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+	return nil
+}
+
+// Pick chooses an endpoint, exclude is avoided when other endpoints are available.
+// If all endpoints are unavailable it still picks one of them.
+func (p *EndpointPool) Pick(exclude *Endpoint) *Endpoint {
+	now := time.Now()
+	candidates := make([]*Endpoint, 0, len(p.endpoints))
+	var excluded *Endpoint
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		ok := e.available(now)
+		e.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if e == exclude {
+			excluded = e
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+	if len(candidates) == 0 && excluded != nil {
+		candidates = append(candidates, excluded)
+	}
+	if len(candidates) == 0 {
+		candidates = p.endpoints
+	}
+
+	switch p.conf.Balancer {
+	case BalanceWeightedRandom:
+		return pickWeightedRandom(candidates)
+	case BalanceLeastInFlight:
+		return pickLeastInFlight(candidates)
+	default:
+		return p.pickRoundRobin(candidates)
+	}
+}
+
+// pickRoundRobin is the smooth weighted round robin of nginx, endpoints are picked in proportion
+// to their weights and interleaved, e.g. weights 5 1 1 picks a a b a c a a.
+func (p *EndpointPool) pickRoundRobin(candidates []*Endpoint) *Endpoint {
+	p.rrMu.Lock()
+	defer p.rrMu.Unlock()
+
+	var best *Endpoint
+	total := 0
+	for _, e := range candidates {
+		e.current += e.Weight
+		total += e.Weight
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	best.current -= total
+	return best
+}
+
+func pickWeightedRandom(candidates []*Endpoint) *Endpoint {
+	total := 0
+	for _, e := range candidates {
+		total += e.Weight
+	}
+	n := int(randInt63n(int64(total)))
+	for _, e := range candidates {
+		n -= e.Weight
+		if n < 0 {
+			return e
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+func pickLeastInFlight(candidates []*Endpoint) *Endpoint {
+	best := candidates[0]
+	for _, e := range candidates[1:] {
+		// 按权重折算 权重高的节点可以承担更多的请求
+		if e.InFlight()*int64(best.Weight) < best.InFlight()*int64(e.Weight) {
+			best = e
+		}
+	}
+	return best
+}
+
+// report records result of a request sent to e, it returns true if e is ejected by this failure.
+func (p *EndpointPool) report(e *Endpoint, success bool) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if success {
+		e.failures = 0
+		return false
+	}
+
+	e.failures++
+	if e.failures >= p.conf.Outlier.ConsecutiveFailures {
+		e.failures = 0
+		e.ejectedUntil = time.Now().Add(p.conf.Outlier.EjectionTime)
+		return true
+	}
+	return false
+}
+
+func (p *EndpointPool) healthCheck() {
+	conf := p.conf.HealthCheck
+	cli := &http.Client{
+		Transport: p.transport,
+		Timeout:   conf.Timeout,
+	}
+
+	ticker := time.NewTicker(conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			for _, e := range p.endpoints {
+				p.probe(cli, e)
+			}
+		}
+	}
+}
+
+func (p *EndpointPool) probe(cli *http.Client, e *Endpoint) {
+	conf := p.conf.HealthCheck
+
+	ok := false
+	resp, err := cli.Get(strings.TrimSuffix(e.URI, "/") + conf.Path)
+	if err == nil {
+		ok = resp.StatusCode >= 200 && resp.StatusCode < 300
+		resp.Body.Close()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if ok {
+		e.probeFails = 0
+		e.probeOks++
+		if e.unhealthy && e.probeOks >= conf.HealthyThreshold {
+			e.unhealthy = false
+			plog.Infof(nil, "client endpoint healthy: %s", e.URI)
+		}
+		return
+	}
+
+	e.probeOks = 0
+	e.probeFails++
+	if !e.unhealthy && e.probeFails >= conf.UnhealthyThreshold {
+		e.unhealthy = true
+		plog.Warnf(nil, "client endpoint unhealthy: %s, err: %v", e.URI, err)
+	}
+}
+
+func validateEndpoints(endpoints []EndpointConfig) error {
+	if len(endpoints) == 0 {
+		return errors.New("endpoint pool: no endpoints")
+	}
+	for _, ec := range endpoints {
+		u, err := urlpkg.Parse(ec.URI)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint pool: invalid uri %q", ec.URI)
+		}
+	}
+	return nil
+}
+
+// getServiceEndpointPool returns the shared endpoint pool of service, health check probes use the
+// transport of the service, nil means DefaultTransport.
+func getServiceEndpointPool(service string, endpoints []EndpointConfig, conf *LoadBalanceConfig, transport *TransportConfig) (*EndpointPool, error) {
+	if err := validateEndpoints(endpoints); err != nil {
+		return nil, err
+	}
+
+	lb := LoadBalanceConfig{}
+	if conf != nil {
+		lb = *conf
+	}
+	var opts []EndpointPoolOption
+	if transport != nil {
+		opts = append(opts, ProbeTransport(getServiceTransport(service, *transport)))
+	}
+
+	// 连接池变化时重建 探活使用新的连接池
+	key := struct {
+		Endpoints []EndpointConfig
+		LB        LoadBalanceConfig
+		Transport *TransportConfig
+	}{endpoints, lb, transport}
+
+	return getShared("endpoints", service, key, func() interface{} {
+		pool, _ := NewEndpointPool(endpoints, lb, opts...)
+		return pool
+	}).(*EndpointPool), nil
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCountServer(status int, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		w.WriteHeader(status)
+		w.Write([]byte(r.URL.Path))
+	}))
+}
+
+func TestEndpointPool_RetryOtherEndpoint(t *testing.T) {
+	var badHits, goodHits int32
+	bad := newCountServer(http.StatusInternalServerError, &badHits)
+	defer bad.Close()
+	good := newCountServer(http.StatusOK, &goodHits)
+	defer good.Close()
+
+	pool, err := NewEndpointPool([]EndpointConfig{
+		{URI: bad.URL + "/v1"},
+		{URI: good.URL + "/v1"},
+	}, LoadBalanceConfig{Outlier: &OutlierConfig{ConsecutiveFailures: 2, EjectionTime: time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	cli := NewClient(WithEndpointPool(pool), Retries(2), Retry(RetryOnIdempotent), Backoff(ConstantBackoff(0)))
+	for i := 0; i < 10; i++ {
+		resp, err := cli.Get("/users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := resp.GetBody()
+		if resp.GetStatusCode() != http.StatusOK || body.String() != "/v1/users" {
+			t.Fatalf("request %d: got %d %q", i, resp.GetStatusCode(), body)
+		}
+	}
+
+	if n := atomic.LoadInt32(&badHits); n != 2 {
+		t.Fatalf("bad endpoint should be ejected after 2 failures, hits: %d", n)
+	}
+	if pool.Endpoints()[0].Available() {
+		t.Fatal("bad endpoint should be unavailable")
+	}
+}
+
+func TestEndpointPool_Balancers(t *testing.T) {
+	endpoints := []EndpointConfig{
+		{URI: "http://a.internal", Weight: 1},
+		{URI: "http://b.internal", Weight: 3},
+	}
+
+	// 平滑加权轮询 按权重比例交替选择
+	rr, _ := NewEndpointPool(endpoints, LoadBalanceConfig{})
+	picks := ""
+	for i := 0; i < 6; i++ {
+		picks += rr.Pick(nil).URI[7:8]
+	}
+	if picks != "babbba" {
+		t.Fatalf("round robin should follow weights, got %s", picks)
+	}
+
+	wr, _ := NewEndpointPool(endpoints, LoadBalanceConfig{Balancer: BalanceWeightedRandom})
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		counts[wr.Pick(nil).URI]++
+	}
+	if counts["http://b.internal"] < 2*counts["http://a.internal"] {
+		t.Fatalf("weighted random not respecting weights: %v", counts)
+	}
+
+	lf, _ := NewEndpointPool(endpoints, LoadBalanceConfig{Balancer: BalanceLeastInFlight})
+	lf.Endpoints()[1].inflight = 6
+	lf.Endpoints()[0].inflight = 1
+	if lf.Pick(nil).URI != "http://a.internal" {
+		t.Fatal("least in-flight should pick a.internal")
+	}
+	if lf.Pick(lf.Endpoints()[0]).URI != "http://b.internal" {
+		t.Fatal("excluded endpoint should be avoided")
+	}
+}
+
+func TestEndpointPool_HealthCheck(t *testing.T) {
+	var healthy int32 = 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	pool, _ := NewEndpointPool([]EndpointConfig{{URI: ts.URL}}, LoadBalanceConfig{
+		HealthCheck: &HealthCheckConfig{Path: "/health", Interval: 10 * time.Millisecond, UnhealthyThreshold: 1},
+	})
+	defer pool.Close()
+
+	waitFor(t, func() bool { return !pool.Endpoints()[0].Available() })
+	atomic.StoreInt32(&healthy, 1)
+	waitFor(t, func() bool { return pool.Endpoints()[0].Available() })
+}
+
+func TestEndpointPool_ProbeTransport(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// 探活使用服务自己的连接池 自签名证书的节点也能探活成功
+	hc := LoadBalanceConfig{HealthCheck: &HealthCheckConfig{Interval: 10 * time.Millisecond, UnhealthyThreshold: 1}}
+	pool, _ := NewEndpointPool([]EndpointConfig{{URI: ts.URL}}, hc, ProbeTransport(ts.Client().Transport))
+	defer pool.Close()
+	untrusted, _ := NewEndpointPool([]EndpointConfig{{URI: ts.URL}}, hc)
+	defer untrusted.Close()
+
+	waitFor(t, func() bool { return !untrusted.Endpoints()[0].Available() })
+	if !pool.Endpoints()[0].Available() {
+		t.Fatal("probe with the service transport should succeed")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,336 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Part multipart/form-data 的一个部分 Path Reader Value 三选一
+type Part struct {
+	Name     string            // 表单字段名
+	FileName string            // 文件名 使用Path时默认为文件名
+	Path     string            // 磁盘文件 每次发送时重新打开 可以安全重试
+	Reader   io.Reader         // 任意内容 实现 io.Seeker 时可以安全重试
+	Value    string            // 普通表单字段
+	Header   map[string]string // 额外的part header 可以覆盖默认的 Content-Type
+}
+
+// streamBody 流式请求体 open 每次返回从头开始的body
+type streamBody struct {
+	contentType string
+	length      int64 // -1 长度未知 使用chunked发送
+	rewindable  bool
+	open        func() (io.ReadCloser, error)
+}
+
+// apply sets body of req, GetBody is only set when the body can be sent again.
+// The body is opened on the first read, so nothing is opened if a wrapper returns without sending it.
+func (b *streamBody) apply(req *http.Request) error {
+	req.Body = &lazyBody{open: b.open}
+	req.ContentLength = b.length
+	if b.length < 0 {
+		req.ContentLength = 0
+	}
+	req.GetBody = nil
+	if b.rewindable {
+		req.GetBody = func() (io.ReadCloser, error) {
+			return &lazyBody{open: b.open}, nil
+		}
+	}
+	if b.contentType != "" {
+		req.Header.Set("Content-Type", b.contentType)
+	}
+
+	return nil
+}
+
+// newReaderBody wraps a raw io.Reader body, the reader is never closed by the client.
+func newReaderBody(r io.Reader, contentType string) (*streamBody, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	src, err := newPartSource(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamBody{
+		contentType: contentType,
+		length:      src.size,
+		rewindable:  src.rewindable,
+		open: func() (io.ReadCloser, error) {
+			r, err := src.open()
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(r), nil
+		},
+	}, nil
+}
+
+// newMultipartBody streams parts without buffering them, fields are written before parts.
+func newMultipartBody(parts []Part, fields map[string]interface{}) (*streamBody, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	for k, v := range fields {
+		if vv, ok := v.([]string); ok {
+			for _, vvv := range vv {
+				mw.WriteField(k, vvv)
+			}
+			continue
+		}
+		mw.WriteField(k, fmt.Sprintf("%v", v))
+	}
+
+	type chunk struct {
+		head []byte
+		src  *partSource
+	}
+	chunks := make([]chunk, 0, len(parts))
+	length := int64(0)
+	rewindable := true
+
+	for i := range parts {
+		p := &parts[i]
+		src, err := p.source()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = mw.CreatePart(p.mimeHeader()); err != nil {
+			return nil, err
+		}
+
+		// 上一个部分的分隔符和当前部分的header
+		head := append([]byte(nil), buf.Bytes()...)
+		buf.Reset()
+		chunks = append(chunks, chunk{head: head, src: src})
+
+		if length >= 0 && src.size >= 0 {
+			length += int64(len(head)) + src.size
+		} else {
+			length = -1
+		}
+		rewindable = rewindable && src.rewindable
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	tail := append([]byte(nil), buf.Bytes()...)
+	if length >= 0 {
+		length += int64(len(tail))
+	}
+
+	open := func() (io.ReadCloser, error) {
+		body := &multiReadCloser{}
+		readers := make([]io.Reader, 0, 2*len(chunks)+1)
+		for _, c := range chunks {
+			r, err := c.src.open()
+			if err != nil {
+				body.Close()
+				return nil, err
+			}
+			if closer, ok := r.(io.Closer); ok && c.src.owned {
+				body.closers = append(body.closers, closer)
+			}
+			readers = append(readers, bytes.NewReader(c.head), r)
+		}
+		readers = append(readers, bytes.NewReader(tail))
+		body.Reader = io.MultiReader(readers...)
+
+		return body, nil
+	}
+
+	return &streamBody{
+		contentType: mw.FormDataContentType(),
+		length:      length,
+		rewindable:  rewindable,
+		open:        open,
+	}, nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func (p *Part) mimeHeader() textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader)
+
+	disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(p.Name))
+	fileName := p.FileName
+	if fileName == "" && p.Path != "" {
+		fileName = filepath.Base(p.Path)
+	}
+	if fileName != "" {
+		disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(fileName))
+		h.Set("Content-Type", "application/octet-stream")
+	}
+	h.Set("Content-Disposition", disposition)
+
+	for k, v := range p.Header {
+		h.Set(k, v)
+	}
+
+	return h
+}
+
+func (p *Part) source() (*partSource, error) {
+	switch {
+	case p.Path != "":
+		info, err := os.Stat(p.Path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("multipart part %s: %s is a directory", p.Name, p.Path)
+		}
+		path := p.Path
+		return &partSource{
+			size:       info.Size(),
+			rewindable: true,
+			owned:      true,
+			open: func() (io.Reader, error) {
+				return os.Open(path)
+			},
+		}, nil
+	case p.Reader != nil:
+		return newPartSource(p.Reader)
+	default:
+		return newPartSource(strings.NewReader(p.Value))
+	}
+}
+
+// partSource 一段可能可以重复读取的内容
+type partSource struct {
+	size       int64 // -1 未知
+	rewindable bool
+	owned      bool // 由client打开 发送完成后需要关闭
+	open       func() (io.Reader, error)
+}
+
+// newPartSource makes r rewindable when it is a bytes.Buffer or an io.Seeker,
+// the content starts from the current offset of r. Only io.ReaderAt gives independent readers,
+// a plain io.Seeker is shared and rewound on every open.
+func newPartSource(r io.Reader) (*partSource, error) {
+	if buf, ok := r.(*bytes.Buffer); ok {
+		// bytes.Buffer 读取后内容就没了 先转成可以重复读取的 bytes.Reader
+		r = bytes.NewReader(buf.Bytes())
+	}
+
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		opened := false
+		return &partSource{
+			size: -1,
+			open: func() (io.Reader, error) {
+				if opened {
+					return nil, errors.New("request body can not be rewound for retry")
+				}
+				opened = true
+				return r, nil
+			},
+		}, nil
+	}
+
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = seeker.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	size := end - offset
+	if ra, ok := r.(io.ReaderAt); ok {
+		// 每次打开都是独立的reader 互不影响
+		return &partSource{
+			size:       size,
+			rewindable: true,
+			open: func() (io.Reader, error) {
+				return io.NewSectionReader(ra, offset, size), nil
+			},
+		}, nil
+	}
+
+	return &partSource{
+		size:       size,
+		rewindable: true,
+		open: func() (io.Reader, error) {
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return r, nil
+		},
+	}, nil
+}
+
+// multiReadCloser closes the files opened for the body
+type multiReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiReadCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	m.closers = nil
+	return err
+}
+
+// lazyBody opens the body on the first read, closing it before that opens nothing
+type lazyBody struct {
+	open func() (io.ReadCloser, error)
+
+	mu     sync.Mutex
+	body   io.ReadCloser
+	err    error
+	closed bool
+}
+
+func (b *lazyBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.body == nil && b.err == nil {
+		if b.closed {
+			b.err = errors.New("read on closed request body")
+		} else {
+			b.body, b.err = b.open()
+		}
+	}
+	body, err := b.body, b.err
+	b.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	return body.Read(p)
+}
+
+func (b *lazyBody) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	body := b.body
+	b.mu.Unlock()
+
+	if body != nil {
+		return body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newUploadServer fails the first `fails` requests with 500 after reading the body,
+// then echoes the multipart form or the raw body.
+func newUploadServer(fails int32) (*httptest.Server, *int32) {
+	var calls int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			body, _ := ioutil.ReadAll(r.Body)
+			if n <= fails {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(w, "%s|%d|%s", r.Header.Get("Content-Type"), r.ContentLength, body)
+			return
+		}
+
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if n <= fails {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%d|%s", r.ContentLength, r.FormValue("batch"))
+		for _, name := range []string{"settlement", "extra"} {
+			f, h, err := r.FormFile(name)
+			if err != nil {
+				continue
+			}
+			content, _ := ioutil.ReadAll(f)
+			fmt.Fprintf(w, "|%s:%s:%s:%s", name, h.Filename, h.Header.Get("Content-Type"), content)
+		}
+	})), &calls
+}
+
+func TestRequest_Multipart(t *testing.T) {
+	ts, calls := newUploadServer(1)
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "settlement.csv")
+	if err := ioutil.WriteFile(path, []byte("id,amount\n1,100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := NewClient(BaseURI(ts.URL), Retries(2), Retry(RetryOnIdempotent), Backoff(ConstantBackoff(0)))
+	resp, err := cli.Post("/upload", Options{
+		Headers:    map[string]interface{}{HeaderIdempotencyKey: "20240101"},
+		FormParams: map[string]interface{}{"batch": "20240101"},
+		Multipart: []Part{
+			{Name: "settlement", Path: path, Header: map[string]string{"Content-Type": "text/csv"}},
+			{Name: "extra", FileName: "extra.txt", Reader: strings.NewReader("hello")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := resp.GetBody()
+
+	parts := strings.SplitN(body.String(), "|", 2)
+	if parts[0] == "-1" || parts[0] == "0" {
+		t.Fatalf("content length should be known, got %s", parts[0])
+	}
+	expect := "20240101|settlement:settlement.csv:text/csv:id,amount\n1,100\n|extra:extra.txt:application/octet-stream:hello"
+	if parts[1] != expect {
+		t.Fatalf("unexpected upload %q", body)
+	}
+	if atomic.LoadInt32(calls) != 2 {
+		t.Fatalf("multipart upload should be retried, calls %d", *calls)
+	}
+}
+
+func TestRequest_ReaderBody(t *testing.T) {
+	ts, calls := newUploadServer(1)
+	defer ts.Close()
+
+	cli := NewClient(BaseURI(ts.URL), Retries(2), Retry(RetryOnIdempotent), Backoff(ConstantBackoff(0)))
+
+	// 可以Seek的body 重试时从头发送
+	f, err := os.Create(filepath.Join(t.TempDir(), "body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString("skip:payload")
+	f.Seek(5, io.SeekStart)
+
+	resp, err := cli.Put("/raw", Options{Body: f, ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := resp.GetBody(); body.String() != "text/plain|7|payload" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if atomic.LoadInt32(calls) != 2 {
+		t.Fatalf("seekable body should be retried, calls %d", *calls)
+	}
+
+	// 不能Seek的body 不重试 返回第一次的响应
+	atomic.StoreInt32(calls, 0)
+	resp, err = cli.Post("/raw", Options{Body: io.MultiReader(bytes.NewReader([]byte("once")))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatusCode() != http.StatusInternalServerError || atomic.LoadInt32(calls) != 1 {
+		t.Fatalf("stream body should not be retried, status %d calls %d", resp.GetStatusCode(), *calls)
+	}
+}
+
+func TestStreamBody_Lazy(t *testing.T) {
+	var opened, closed int32
+	body := &streamBody{
+		length:     -1,
+		rewindable: true,
+		open: func() (io.ReadCloser, error) {
+			atomic.AddInt32(&opened, 1)
+			return &multiReadCloser{
+				Reader:  strings.NewReader("part"),
+				closers: []io.Closer{closerFunc(func() error { atomic.AddInt32(&closed, 1); return nil })},
+			}, nil
+		},
+	}
+	req, _ := http.NewRequest(http.MethodPost, "http://upload.internal", nil)
+	if err := body.apply(req); err != nil {
+		t.Fatal(err)
+	}
+
+	// wrapper 直接返回时 请求体没有被读取 不打开文件
+	req.Body.Close()
+	if n := atomic.LoadInt32(&opened); n != 0 {
+		t.Fatalf("body should not be opened before read, opened %d", n)
+	}
+
+	retry, _ := req.GetBody()
+	if content, _ := ioutil.ReadAll(retry); string(content) != "part" {
+		t.Fatalf("unexpected body %q", content)
+	}
+	retry.Close()
+	if atomic.LoadInt32(&opened) != 1 || atomic.LoadInt32(&closed) != 1 {
+		t.Fatalf("opened body should be closed, opened %d closed %d", opened, closed)
+	}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	StateClosed BreakerState = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerConfig 熔断配置 按host独立统计
+type BreakerConfig struct {
+	ErrorRatio       float64       `json:"error_ratio" yaml:"error_ratio"`               // 失败率阈值 错误或5xx占比
+	MinRequests      int           `json:"min_requests" yaml:"min_requests"`             // 窗口内最少请求数 达到后才计算失败率
+	Window           time.Duration `json:"window" yaml:"window"`                         // 统计窗口 关闭状态下每个窗口清零
+	CoolDown         time.Duration `json:"cool_down" yaml:"cool_down"`                   // 打开后的冷却时间 之后进入半开
+	HalfOpenRequests int           `json:"half_open_requests" yaml:"half_open_requests"` // 半开状态允许的探测请求数
+}
+
+func (c *BreakerConfig) checkConf() {
+	if c.ErrorRatio <= 0 || c.ErrorRatio > 1 {
+		c.ErrorRatio = 0.5
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.Window <= 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.CoolDown <= 0 {
+		c.CoolDown = 5 * time.Second
+	}
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = 1
+	}
+}
+
+// CircuitBreaker 按host熔断 closed -> open -> half-open
+type CircuitBreaker struct {
+	conf      BreakerConfig
+	mu        sync.Mutex
+	hosts     map[string]*hostBreaker
+	lastSweep time.Time // 上次清理空闲host的时间
+}
+
+// NewCircuitBreaker instances a circuit breaker, zero fields of conf use defaults.
+func NewCircuitBreaker(conf BreakerConfig) *CircuitBreaker {
+	conf.checkConf()
+	return &CircuitBreaker{
+		conf:      conf,
+		hosts:     make(map[string]*hostBreaker),
+		lastSweep: time.Now(),
+	}
+}
+
+// Wrapper is the WrapperChain of the breaker, use it with Wrap(cb.Wrapper).
+func (cb *CircuitBreaker) Wrapper(next Wrapper) Wrapper {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		host := req.GetRequest().URL.Host
+		hb := cb.get(host)
+
+		generation, err := hb.allow(time.Now())
+		if err != nil {
+			if req.Log() != nil {
+				req.Log().Warnf("client circuit breaker open, host: %s", host)
+			}
+			return nil, ErrCircuitOpen.Wrap(host)
+		}
+
+		resp, err := next(ctx, req)
+
+		// 调用方主动取消不能说明上游的状态 只释放半开状态的探测名额
+		if errorCode(err) == CodeCanceled {
+			hb.release(generation)
+			return resp, err
+		}
+
+		from, to := hb.report(generation, isBreakerSuccess(resp, err), time.Now())
+		if from != to && req.Log() != nil {
+			req.Log().Warnf("client circuit breaker %s -> %s, host: %s", from, to, host)
+		}
+
+		return resp, err
+	}
+}
+
+// State returns current state of host.
+func (cb *CircuitBreaker) State(host string) BreakerState {
+	hb := cb.get(host)
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.refresh(time.Now())
+	return hb.state
+}
+
+func (cb *CircuitBreaker) get(host string) *hostBreaker {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	hb, ok := cb.hosts[host]
+	if !ok {
+		now := time.Now()
+		if now.Sub(cb.lastSweep) >= cb.conf.Window {
+			cb.sweep(now)
+		}
+		hb = &hostBreaker{
+			conf:        &cb.conf,
+			windowStart: now,
+			lastSeen:    now,
+		}
+		cb.hosts[host] = hb
+	}
+	return hb
+}
+
+// sweep 清理关闭状态下超过一个统计窗口没有请求的host 这些host的统计已经清零 删除不丢失状态 调用方需持有锁
+func (cb *CircuitBreaker) sweep(now time.Time) {
+	for host, hb := range cb.hosts {
+		hb.mu.Lock()
+		hb.refresh(now)
+		idle := hb.state == StateClosed && now.Sub(hb.lastSeen) >= cb.conf.Window
+		hb.mu.Unlock()
+		if idle {
+			delete(cb.hosts, host)
+		}
+	}
+	cb.lastSweep = now
+}
+
+func isBreakerSuccess(resp *Response, err error) bool {
+	if err != nil {
+		return false
+	}
+	if resp != nil && resp.hresp != nil && resp.hresp.StatusCode >= http.StatusInternalServerError {
+		return false
+	}
+	return true
+}
+
+// hostBreaker 单个host的熔断状态
+type hostBreaker struct {
+	conf *BreakerConfig
+	mu   sync.Mutex
+
+	state      BreakerState
+	generation uint64 // 状态切换时递增 丢弃旧状态下发出请求的结果
+
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	lastSeen    time.Time // 最近一次请求的时间
+
+	halfOpenInflight int
+	halfOpenSuccess  int
+}
+
+func (hb *hostBreaker) allow(now time.Time) (uint64, error) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	hb.refresh(now)
+	hb.lastSeen = now
+	switch hb.state {
+	case StateOpen:
+		return hb.generation, ErrCircuitOpen
+	case StateHalfOpen:
+		if hb.halfOpenInflight >= hb.conf.HalfOpenRequests {
+			return hb.generation, ErrCircuitOpen
+		}
+		hb.halfOpenInflight++
+	}
+
+	return hb.generation, nil
+}
+
+func (hb *hostBreaker) report(generation uint64, success bool, now time.Time) (from, to BreakerState) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	hb.refresh(now)
+	from = hb.state
+	if generation != hb.generation {
+		return from, from
+	}
+
+	switch hb.state {
+	case StateClosed:
+		hb.requests++
+		if !success {
+			hb.failures++
+		}
+		if hb.requests >= hb.conf.MinRequests &&
+			float64(hb.failures)/float64(hb.requests) >= hb.conf.ErrorRatio {
+			hb.setState(StateOpen, now)
+		}
+	case StateHalfOpen:
+		hb.halfOpenInflight--
+		if !success {
+			hb.setState(StateOpen, now)
+			break
+		}
+		hb.halfOpenSuccess++
+		if hb.halfOpenSuccess >= hb.conf.HalfOpenRequests {
+			hb.setState(StateClosed, now)
+		}
+	}
+
+	return from, hb.state
+}
+
+// release frees the half open slot taken by allow without recording the result.
+func (hb *hostBreaker) release(generation uint64) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	if generation == hb.generation && hb.state == StateHalfOpen {
+		hb.halfOpenInflight--
+	}
+}
+
+// refresh 处理基于时间的状态变化 调用方需持有锁
+func (hb *hostBreaker) refresh(now time.Time) {
+	switch hb.state {
+	case StateClosed:
+		if now.Sub(hb.windowStart) >= hb.conf.Window {
+			hb.requests, hb.failures = 0, 0
+			hb.windowStart = now
+		}
+	case StateOpen:
+		if now.Sub(hb.openedAt) >= hb.conf.CoolDown {
+			hb.setState(StateHalfOpen, now)
+		}
+	}
+}
+
+func (hb *hostBreaker) setState(state BreakerState, now time.Time) {
+	hb.state = state
+	hb.generation++
+	hb.requests, hb.failures = 0, 0
+	hb.halfOpenInflight, hb.halfOpenSuccess = 0, 0
+	hb.windowStart = now
+	if state == StateOpen {
+		hb.openedAt = now
+	}
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yulecd/pp-common/perrors"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing, hits int32 = 1, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	cb := NewCircuitBreaker(BreakerConfig{
+		ErrorRatio:  0.5,
+		MinRequests: 3,
+		Window:      time.Minute,
+		CoolDown:    50 * time.Millisecond,
+	})
+	cli := NewClient(Wrap(cb.Wrapper))
+
+	for i := 0; i < 3; i++ {
+		resp, err := cli.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("request %d: unexpected error %v", i, err)
+		}
+		resp.GetBody()
+	}
+
+	host := mustHost(t, ts.URL)
+	if cb.State(host) != StateOpen {
+		t.Fatalf("expect open, got %s", cb.State(host))
+	}
+
+	_, err := cli.Get(ts.URL)
+	if e, ok := err.(*perrors.Error); !ok || e.Code() != CodeCircuitOpen {
+		t.Fatalf("expect circuit open error, got %v", err)
+	}
+	if atomic.LoadInt32(&hits) != 3 {
+		t.Fatalf("open circuit should not hit upstream, hits: %d", hits)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&failing, 0)
+	if cb.State(host) != StateHalfOpen {
+		t.Fatalf("expect half-open, got %s", cb.State(host))
+	}
+
+	// 调用方取消的探测不记录结果 只释放名额
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = cli.WithContext(ctx).Get(ts.URL); errorCode(err) != CodeCanceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+	if cb.State(host) != StateHalfOpen {
+		t.Fatalf("canceled probe should not close the circuit, got %s", cb.State(host))
+	}
+
+	resp, err := cli.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("half-open probe error: %v", err)
+	}
+	resp.GetBody()
+	if cb.State(host) != StateClosed {
+		t.Fatalf("expect closed, got %s", cb.State(host))
+	}
+}
+
+func mustHost(t *testing.T, uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
+func TestCircuitBreaker_Sweep(t *testing.T) {
+	cb := NewCircuitBreaker(BreakerConfig{Window: 20 * time.Millisecond})
+	for i := 0; i < 100; i++ {
+		cb.State(fmt.Sprintf("10.0.0.%d:80", i))
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// 关闭状态下空闲超过一个窗口的host在下次新增host时清理
+	cb.State("10.0.1.1:80")
+	cb.mu.Lock()
+	n := len(cb.hosts)
+	cb.mu.Unlock()
+	if n != 1 {
+		t.Fatalf("idle hosts should be evicted, got %d", n)
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"sync"
+)
+
+// RetryBudgetConfig 重试预算配置
+// 每个请求存入 Ratio 个令牌 每次重试消耗一个令牌 重试量不会超过请求量的 Ratio 倍
+type RetryBudgetConfig struct {
+	Ratio     float64 `json:"ratio" yaml:"ratio"`           // 允许的重试占请求的比例
+	MaxTokens float64 `json:"max_tokens" yaml:"max_tokens"` // 令牌上限 也是初始令牌数 决定低流量时可以突发的重试次数
+}
+
+func (c *RetryBudgetConfig) checkConf() {
+	if c.Ratio <= 0 {
+		c.Ratio = 0.1
+	}
+	if c.MaxTokens <= 0 {
+		c.MaxTokens = 10
+	}
+}
+
+// RetryBudget 重试预算 按client或服务共享的令牌桶
+type RetryBudget struct {
+	mu     sync.Mutex
+	conf   RetryBudgetConfig
+	tokens float64
+}
+
+// NewRetryBudget instances a retry budget, zero fields of conf use defaults.
+func NewRetryBudget(conf RetryBudgetConfig) *RetryBudget {
+	conf.checkConf()
+	return &RetryBudget{
+		conf:   conf,
+		tokens: conf.MaxTokens,
+	}
+}
+
+// deposit is called once for every request.
+func (b *RetryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += b.conf.Ratio
+	if b.tokens > b.conf.MaxTokens {
+		b.tokens = b.conf.MaxTokens
+	}
+}
+
+// withdraw is called before every retry, it returns false when the budget is exhausted.
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Tokens returns the remaining tokens.
+func (b *RetryBudget) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens
+}
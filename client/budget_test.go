@@ -0,0 +1,52 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryBudget(t *testing.T) {
+	b := NewRetryBudget(RetryBudgetConfig{Ratio: 0.5, MaxTokens: 1})
+	if !b.withdraw() {
+		t.Fatal("initial tokens should allow one retry")
+	}
+	if b.withdraw() {
+		t.Fatal("budget should be exhausted")
+	}
+	b.deposit()
+	if b.withdraw() {
+		t.Fatal("half token should not allow retry")
+	}
+	b.deposit()
+	b.deposit()
+	if !b.withdraw() {
+		t.Fatal("two requests should allow one retry")
+	}
+}
+
+func TestRetryBudget_Exhausted(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("busy"))
+	}))
+	defer ts.Close()
+
+	budget := NewRetryBudget(RetryBudgetConfig{Ratio: 0.01, MaxTokens: 1})
+	cli := NewClient(Retries(5), Retry(RetryOnIdempotent), Backoff(ConstantBackoff(0)), WithRetryBudget(budget))
+
+	resp, err := cli.Get(ts.URL)
+	if errorCode(err) != CodeRetryBudgetExhausted {
+		t.Fatalf("expect retry budget exhausted, got %v", err)
+	}
+	// 预算耗尽时仍然返回上游的最后一次响应
+	if body, _ := resp.GetBody(); resp.GetStatusCode() != http.StatusInternalServerError || body.String() != "busy" {
+		t.Fatalf("last response should be returned, got %d %q", resp.GetStatusCode(), body)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("expect 1 request and 1 retry, hits: %d", n)
+	}
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BulkheadConfig 并发隔离配置
+type BulkheadConfig struct {
+	MaxConcurrent int           `json:"max_concurrent" yaml:"max_concurrent"` // 最大并发请求数
+	MaxWait       time.Duration `json:"max_wait" yaml:"max_wait"`             // 并发已满时的等待时间 0 立即失败
+}
+
+func (c *BulkheadConfig) checkConf() {
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = 100
+	}
+}
+
+// Bulkhead 限制同时进行中的请求数
+type Bulkhead struct {
+	conf BulkheadConfig
+	sem  chan struct{}
+}
+
+// NewBulkhead instances a bulkhead, zero fields of conf use defaults.
+func NewBulkhead(conf BulkheadConfig) *Bulkhead {
+	conf.checkConf()
+	return &Bulkhead{
+		conf: conf,
+		sem:  make(chan struct{}, conf.MaxConcurrent),
+	}
+}
+
+// Wrapper is the WrapperChain of the bulkhead, use it with Wrap(b.Wrapper).
+func (b *Bulkhead) Wrapper(next Wrapper) Wrapper {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		if err := b.acquire(ctx); err != nil {
+			if req.Log() != nil {
+				req.Log().Warnf("client bulkhead rejected, host: %s, err: %v", req.GetRequest().URL.Host, err)
+			}
+			return nil, err
+		}
+		defer b.release()
+
+		return next(ctx, req)
+	}
+}
+
+// InFlight returns the number of in-flight requests.
+func (b *Bulkhead) InFlight() int {
+	return len(b.sem)
+}
+
+func (b *Bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.sem <- struct{}{}:
+		return nil
+	default:
+	}
+
+	full := ErrBulkheadFull.Wrap(fmt.Sprintf("max concurrent %d", b.conf.MaxConcurrent))
+	if b.conf.MaxWait <= 0 {
+		return full
+	}
+
+	timer := time.NewTimer(b.conf.MaxWait)
+	defer timer.Stop()
+
+	select {
+	case b.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return full
+	}
+}
+
+func (b *Bulkhead) release() {
+	<-b.sem
+}
@@ -0,0 +1,409 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/yulecd/pp-common/redis"
+)
+
+// CacheConfig 响应缓存配置 只缓存GET请求 按响应的 Cache-Control Expires ETag Last-Modified 处理
+type CacheConfig struct {
+	MaxEntries  int           `json:"max_entries" yaml:"max_entries"`     // 内存缓存的条数 默认 1000
+	MaxBodySize int64         `json:"max_body_size" yaml:"max_body_size"` // 超过的响应不缓存 默认 1MB
+	KeepStale   time.Duration `json:"keep_stale" yaml:"keep_stale"`       // 过期后保留多久用于 If-None-Match 重新验证 默认 10m
+	Redis       string        `json:"redis" yaml:"redis"`                 // redis 服务名 配置后多个实例共享缓存 不使用内存缓存
+	RedisPrefix string        `json:"redis_prefix" yaml:"redis_prefix"`   // 默认 client:cache:
+}
+
+func (c *CacheConfig) checkConf() {
+	if c.MaxEntries <= 0 {
+		c.MaxEntries = 1000
+	}
+	if c.MaxBodySize <= 0 {
+		c.MaxBodySize = 1 << 20
+	}
+	if c.KeepStale <= 0 {
+		c.KeepStale = 10 * time.Minute
+	}
+	if c.RedisPrefix == "" {
+		c.RedisPrefix = "client:cache:"
+	}
+}
+
+// CacheStore 缓存后端 Get 未命中时返回 nil, nil
+type CacheStore interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// cacheEntry 缓存的响应
+type cacheEntry struct {
+	StatusCode int               `json:"status_code"`
+	Status     string            `json:"status"`
+	Header     http.Header       `json:"header"`
+	Body       []byte            `json:"body"`
+	Expires    time.Time         `json:"expires"` // 在此之前不需要重新验证
+	Vary       map[string]string `json:"vary"`    // 响应 Vary 的请求头和请求时的值
+}
+
+// matches reports whether the entry can answer req according to Vary.
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for k, v := range e.Vary {
+		if req.Header.Get(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// response builds a response of the entry for req.
+func (e *cacheEntry) response(req *Request) *Response {
+	resp := syntheticResponse(req, e.StatusCode, e.Header.Clone(), e.Body)
+	resp.hresp.Status = e.Status
+	resp.cached = true
+	return resp
+}
+
+// Cache 响应缓存 新鲜的缓存直接返回 过期的缓存带上 If-None-Match If-Modified-Since 重新验证
+type Cache struct {
+	conf  CacheConfig
+	store CacheStore
+}
+
+// NewCache instances a cache, entries are kept in memory or in redis if conf.Redis is set.
+func NewCache(conf CacheConfig) *Cache {
+	conf.checkConf()
+
+	var store CacheStore
+	if conf.Redis != "" {
+		store = NewRedisCacheStore(conf.Redis, conf.RedisPrefix)
+	} else {
+		store = NewLRUCacheStore(conf.MaxEntries)
+	}
+
+	return NewCacheWithStore(conf, store)
+}
+
+// NewCacheWithStore instances a cache with a custom store.
+func NewCacheWithStore(conf CacheConfig, store CacheStore) *Cache {
+	conf.checkConf()
+	return &Cache{
+		conf:  conf,
+		store: store,
+	}
+}
+
+// Wrapper is the WrapperChain of the cache, use it with Wrap(c.Wrapper).
+// Put it before rate limiter so that cache hits are not limited.
+func (c *Cache) Wrapper(next Wrapper) Wrapper {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		hreq := req.GetRequest()
+		if !c.cacheable(req) {
+			return next(ctx, req)
+		}
+
+		key := c.key(hreq)
+		entry := c.load(ctx, req, key)
+		if entry != nil {
+			if time.Now().Before(entry.Expires) && !hasDirective(hreq.Header, "no-cache") {
+				return entry.response(req), nil
+			}
+			if etag := entry.Header.Get("ETag"); etag != "" {
+				hreq.Header.Set("If-None-Match", etag)
+			}
+			if modified := entry.Header.Get("Last-Modified"); modified != "" {
+				hreq.Header.Set("If-Modified-Since", modified)
+			}
+		}
+
+		resp, err := next(ctx, req)
+		if err != nil || resp == nil || resp.hresp == nil {
+			return resp, err
+		}
+
+		if entry != nil && resp.hresp.StatusCode == http.StatusNotModified {
+			// 内容没有变化 使用新的缓存头更新过期时间
+			for _, k := range []string{"Cache-Control", "Expires", "Date", "ETag", "Last-Modified", "Age"} {
+				if v := resp.hresp.Header.Get(k); v != "" {
+					entry.Header.Set(k, v)
+				}
+			}
+			resp.discard()
+			c.save(ctx, req, key, entry)
+			return entry.response(req), nil
+		}
+
+		if entry = c.entry(resp); entry != nil {
+			c.save(ctx, req, key, entry)
+		}
+
+		return resp, nil
+	}
+}
+
+// cacheable reports whether req can be answered from cache.
+func (c *Cache) cacheable(req *Request) bool {
+	hreq := req.GetRequest()
+	if hreq.Method != http.MethodGet || req.opts.Stream || hasDirective(hreq.Header, "no-store") {
+		return false
+	}
+	// 调用方自己做条件请求或者范围请求时不处理 带用户凭证的请求响应可能因人而异 不缓存
+	for _, k := range []string{"If-None-Match", "If-Modified-Since", "Range", "Authorization", "Cookie"} {
+		if hreq.Header.Get(k) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// entry builds the cache entry of resp, nil if it must not be stored.
+func (c *Cache) entry(resp *Response) *cacheEntry {
+	hresp := resp.hresp
+	// 缓存在多个调用方之间共享 private 和设置cookie的响应不缓存
+	if hresp.StatusCode != http.StatusOK || hasDirective(hresp.Header, "no-store") ||
+		hasDirective(hresp.Header, "private") || hresp.Header.Get("Set-Cookie") != "" {
+		return nil
+	}
+	if hresp.ContentLength > c.conf.MaxBodySize {
+		return nil
+	}
+
+	vary := make(map[string]string)
+	for _, line := range hresp.Header.Values("Vary") {
+		for _, k := range strings.Split(line, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if k == "*" {
+				return nil
+			}
+			if k != "" {
+				vary[k] = resp.hreq.Header.Get(k)
+			}
+		}
+	}
+
+	expires := freshUntil(hresp.Header, time.Now())
+	if !time.Now().Before(expires) && hresp.Header.Get("ETag") == "" && hresp.Header.Get("Last-Modified") == "" {
+		// 不能直接使用也不能重新验证
+		return nil
+	}
+
+	body, err := resp.GetBody()
+	if err != nil || int64(len(body)) > c.conf.MaxBodySize {
+		return nil
+	}
+
+	return &cacheEntry{
+		StatusCode: hresp.StatusCode,
+		Status:     hresp.Status,
+		Header:     hresp.Header.Clone(),
+		Body:       body,
+		Expires:    expires,
+		Vary:       vary,
+	}
+}
+
+func (c *Cache) key(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) load(ctx context.Context, req *Request, key string) *cacheEntry {
+	content, err := c.store.Get(ctx, key)
+	if err != nil {
+		if req.Log() != nil {
+			req.Log().Warnf("client cache get err: %v", err)
+		}
+		return nil
+	}
+	if content == nil {
+		return nil
+	}
+
+	entry := &cacheEntry{}
+	if json.Unmarshal(content, entry) != nil || !entry.matches(req.GetRequest()) {
+		return nil
+	}
+	return entry
+}
+
+func (c *Cache) save(ctx context.Context, req *Request, key string, entry *cacheEntry) {
+	entry.Expires = freshUntil(entry.Header, time.Now())
+	ttl := time.Until(entry.Expires)
+	if ttl < 0 {
+		ttl = 0
+	}
+	if entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != "" {
+		ttl += c.conf.KeepStale
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	content, _ := json.Marshal(entry)
+	if err := c.store.Set(ctx, key, content, ttl); err != nil && req.Log() != nil {
+		req.Log().Warnf("client cache set err: %v", err)
+	}
+}
+
+// freshUntil returns when the response should be revalidated, it is now if it must be revalidated every time.
+func freshUntil(h http.Header, now time.Time) time.Time {
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	if _, ok := cc["no-cache"]; ok {
+		return now
+	}
+
+	age, _ := strconv.Atoi(h.Get("Age"))
+	if v, ok := cc["max-age"]; ok {
+		maxAge, err := strconv.Atoi(v)
+		if err != nil {
+			return now
+		}
+		return now.Add(time.Duration(maxAge-age) * time.Second)
+	}
+
+	if v := h.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return now
+		}
+		if date, err := http.ParseTime(h.Get("Date")); err == nil {
+			// 按服务端时间计算有效期 避免时钟不一致
+			return now.Add(expires.Sub(date))
+		}
+		return expires
+	}
+
+	return now
+}
+
+// hasDirective reports whether the Cache-Control header of h has directive.
+func hasDirective(h http.Header, directive string) bool {
+	_, ok := parseCacheControl(h.Get("Cache-Control"))[directive]
+	return ok
+}
+
+func parseCacheControl(v string) map[string]string {
+	cc := make(map[string]string)
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, value = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		cc[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+	return cc
+}
+
+// lruCacheStore 内存 LRU 缓存
+type lruCacheStore struct {
+	mu      sync.Mutex
+	max     int
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type lruItem struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCacheStore instances an in-memory store which keeps at most maxEntries entries.
+func NewLRUCacheStore(maxEntries int) CacheStore {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	return &lruCacheStore{
+		max:     maxEntries,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (s *lruCacheStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	item := el.Value.(*lruItem)
+	if time.Now().After(item.expires) {
+		s.ll.Remove(el)
+		delete(s.entries, key)
+		return nil, nil
+	}
+	s.ll.MoveToFront(el)
+	return item.value, nil
+}
+
+func (s *lruCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := &lruItem{key: key, value: value, expires: time.Now().Add(ttl)}
+	if el, ok := s.entries[key]; ok {
+		el.Value = item
+		s.ll.MoveToFront(el)
+		return nil
+	}
+
+	s.entries[key] = s.ll.PushFront(item)
+	for s.ll.Len() > s.max {
+		oldest := s.ll.Back()
+		s.ll.Remove(oldest)
+		delete(s.entries, oldest.Value.(*lruItem).key)
+	}
+	return nil
+}
+
+// redisCacheStore 使用 redis 包的客户端 多个实例共享缓存
+type redisCacheStore struct {
+	name   string
+	prefix string
+}
+
+// NewRedisCacheStore instances a store on the redis client named name, the client is looked up on every call
+// so it can be initialized later. Calls are treated as misses if it is not initialized.
+func NewRedisCacheStore(name, prefix string) CacheStore {
+	return &redisCacheStore{
+		name:   name,
+		prefix: prefix,
+	}
+}
+
+func (s *redisCacheStore) Get(ctx context.Context, key string) ([]byte, error) {
+	cli := redis.GetClient(s.name)
+	if cli == nil {
+		return nil, nil
+	}
+
+	content, err := cli.Get(ctx, s.prefix+key).Bytes()
+	if err == goredis.Nil {
+		return nil, nil
+	}
+	return content, err
+}
+
+func (s *redisCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	cli := redis.GetClient(s.name)
+	if cli == nil {
+		return nil
+	}
+	return cli.Set(ctx, s.prefix+key, value, ttl).Err()
+}
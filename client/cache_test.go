@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCacheServer answers with cacheControl and etag v1, 304 is returned when the client has v1.
+func newCacheServer(cacheControl string, full, notModified *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(full, 1)
+		w.Write([]byte("rates:" + r.URL.RawQuery))
+	}))
+}
+
+func TestCache_MaxAge(t *testing.T) {
+	var full, notModified int32
+	ts := newCacheServer("max-age=60", &full, &notModified)
+	defer ts.Close()
+
+	cli := NewClient(BaseURI(ts.URL), Wrap(NewCache(CacheConfig{}).Wrapper))
+	for i := 0; i < 3; i++ {
+		resp, err := cli.Get("/rates?c=usd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := resp.GetBody(); body.String() != "rates:c=usd" || resp.FromCache() != (i > 0) {
+			t.Fatalf("call %d: unexpected body %q or cache state %v", i, body, resp.FromCache())
+		}
+	}
+	if _, err := cli.Get("/rates?c=eur"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&full); n != 2 {
+		t.Fatalf("fresh entries should not be requested again, got %d", n)
+	}
+
+	// 请求带 no-store 时不使用缓存
+	if resp, _ := cli.Get("/rates?c=usd", Options{Headers: map[string]interface{}{"Cache-Control": "no-store"}}); resp.FromCache() {
+		t.Fatal("no-store request should bypass cache")
+	}
+}
+
+func TestCache_Private(t *testing.T) {
+	var full, notModified int32
+	private := newCacheServer("private, max-age=60", &full, &notModified)
+	defer private.Close()
+
+	cli := NewClient(BaseURI(private.URL), Wrap(NewCache(CacheConfig{}).Wrapper))
+	cli.Get("/profile")
+	if resp, _ := cli.Get("/profile"); resp.FromCache() || atomic.LoadInt32(&full) != 2 {
+		t.Fatalf("private response should not be cached, got %d requests", full)
+	}
+
+	// 带凭证的请求既不读缓存也不写缓存
+	var sharedFull int32
+	ts := newCacheServer("max-age=60", &sharedFull, &notModified)
+	defer ts.Close()
+	cli = NewClient(BaseURI(ts.URL), Wrap(NewCache(CacheConfig{}).Wrapper))
+	for _, headers := range []map[string]interface{}{
+		{"Authorization": "Bearer alice"},
+		{"Cookie": "session=bob"},
+		nil,
+		{"Authorization": "Bearer carol"},
+	} {
+		if resp, _ := cli.Get("/profile", Options{Headers: headers}); resp.FromCache() {
+			t.Fatalf("request with %v should not use cache", headers)
+		}
+	}
+	if resp, _ := cli.Get("/profile"); !resp.FromCache() || atomic.LoadInt32(&sharedFull) != 4 {
+		t.Fatalf("only the request without credentials should be cached, got %d requests", sharedFull)
+	}
+}
+
+func TestCache_Revalidate(t *testing.T) {
+	var full, notModified int32
+	ts := newCacheServer("no-cache", &full, &notModified)
+	defer ts.Close()
+
+	cli := NewClient(BaseURI(ts.URL), Wrap(NewCache(CacheConfig{}).Wrapper))
+	for i := 0; i < 3; i++ {
+		resp, err := cli.Get("/config")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := resp.GetBody(); body.String() != "rates:" || resp.GetStatusCode() != http.StatusOK {
+			t.Fatalf("call %d: unexpected response %d %q", i, resp.GetStatusCode(), body)
+		}
+	}
+	if atomic.LoadInt32(&full) != 1 || atomic.LoadInt32(&notModified) != 2 {
+		t.Fatalf("expect 1 full response and 2 revalidations, got %d %d", full, notModified)
+	}
+
+	// 不允许缓存的响应每次都请求
+	var storeFull, storeNotModified int32
+	nostore := newCacheServer("no-store", &storeFull, &storeNotModified)
+	defer nostore.Close()
+	cli = NewClient(BaseURI(nostore.URL), Wrap(NewCache(CacheConfig{}).Wrapper))
+	cli.Get("/config")
+	cli.Get("/config")
+	if atomic.LoadInt32(&storeFull) != 2 || atomic.LoadInt32(&storeNotModified) != 0 {
+		t.Fatalf("no-store response should not be cached, got %d %d", storeFull, storeNotModified)
+	}
+}
+
+func TestCache_Stores(t *testing.T) {
+	ctx := context.Background()
+	store := NewLRUCacheStore(2)
+	store.Set(ctx, "a", []byte("1"), time.Minute)
+	store.Set(ctx, "b", []byte("2"), time.Minute)
+	store.Get(ctx, "a")
+	store.Set(ctx, "c", []byte("3"), time.Minute)
+	if v, _ := store.Get(ctx, "b"); v != nil {
+		t.Fatal("least recently used entry should be evicted")
+	}
+	if v, _ := store.Get(ctx, "a"); string(v) != "1" {
+		t.Fatalf("recently used entry should be kept, got %q", v)
+	}
+	store.Set(ctx, "d", []byte("4"), -time.Second)
+	if v, _ := store.Get(ctx, "d"); v != nil {
+		t.Fatal("expired entry should be missed")
+	}
+
+	// redis 没有初始化时不使用缓存 请求正常发送
+	var full, notModified int32
+	ts := newCacheServer("max-age=60", &full, &notModified)
+	defer ts.Close()
+	cli := NewClient(BaseURI(ts.URL), Wrap(NewCache(CacheConfig{Redis: "client-cache-test"}).Wrapper))
+	for i := 0; i < 2; i++ {
+		if resp, err := cli.Get("/rates"); err != nil || resp.FromCache() {
+			t.Fatalf("uninitialized redis should not serve cache: %v", err)
+		}
+	}
+}
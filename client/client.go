@@ -10,12 +10,13 @@ type Client interface{}
 type Option func(*Options)
 
 var (
-	DefaultClient   *Request = newHttpClient()
-	DefaultBackoff           = exponentialBackoff
-	DefaultRetry             = RetryOnError
-	DefaultWrappers          = make([]WrapperChain, 0)
-	DefaultRetries           = 1
-	DefaultTimeout           = time.Second * 30
+	DefaultClient        *Request = newHttpClient()
+	DefaultBackoff                = exponentialBackoff
+	DefaultRetry                  = RetryOnError
+	DefaultWrappers               = make([]WrapperChain, 0)
+	DefaultRetries                = 1
+	DefaultTimeout                = time.Second * 30
+	DefaultMaxRetryAfter          = time.Second * 30
 
 	NewClient func(...Option) *Request = newHttpClient
 )
@@ -40,6 +41,11 @@ func WithDefaultTimeout(t time.Duration) {
 	DefaultTimeout = t
 }
 
+// WithDefaultMaxRetryAfter sets the default upper bound of upstream Retry-After.
+func WithDefaultMaxRetryAfter(t time.Duration) {
+	DefaultMaxRetryAfter = t
+}
+
 // WithDefaultBackoff sets default backoff function policy.
 func WithDefaultBackoff(fn BackoffFunc) {
 	DefaultBackoff = fn
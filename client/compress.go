@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+
+	// acceptEncoding 请求默认声明的压缩格式 响应会透明解压
+	acceptEncoding = "gzip, deflate"
+)
+
+// CompressConfig 请求体压缩配置 只压缩 FormParams 和 JSON 请求体 流式请求体不压缩
+type CompressConfig struct {
+	Encoding string `json:"encoding" yaml:"encoding"` // gzip 或 deflate 默认 gzip
+	MinSize  int    `json:"min_size" yaml:"min_size"` // 请求体小于它时不压缩 默认 1024
+}
+
+func (c *CompressConfig) checkConf() {
+	c.Encoding = strings.ToLower(c.Encoding)
+	if c.Encoding != EncodingDeflate {
+		c.Encoding = EncodingGzip
+	}
+	if c.MinSize <= 0 {
+		c.MinSize = 1024
+	}
+}
+
+// compressBody compresses the parsed request body, the encoding is returned if it is compressed.
+func (r *Request) compressBody() (string, error) {
+	conf := r.opts.compress
+	if conf == nil || r.stream != nil || r.body == nil {
+		return "", nil
+	}
+
+	data, err := ioutil.ReadAll(r.body)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < conf.MinSize {
+		r.body = bytes.NewReader(data)
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if conf.Encoding == EncodingDeflate {
+		w = zlib.NewWriter(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	if _, err = w.Write(data); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	r.body = bytes.NewReader(buf.Bytes())
+	r.plainBody = data
+	return conf.Encoding, nil
+}
+
+// acceptEncoding declares the supported encodings unless the caller sets Accept-Encoding itself,
+// responses are decompressed only in that case.
+func (r *Request) acceptEncoding() {
+	if r.req.Header.Get("Accept-Encoding") != "" {
+		return
+	}
+	r.req.Header.Set("Accept-Encoding", acceptEncoding)
+	r.decompress = true
+}
+
+// decompressResponse decompresses body of resp in place, the length is unknown after that.
+func decompressResponse(resp *http.Response) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding != EncodingGzip && encoding != EncodingDeflate {
+		return
+	}
+
+	resp.Body = &decompressBody{ReadCloser: resp.Body, encoding: encoding}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// decompressBody 读取时才创建解压器 空的响应体不会报错
+type decompressBody struct {
+	io.ReadCloser
+	encoding string
+	r        io.Reader
+}
+
+func (b *decompressBody) Read(p []byte) (int, error) {
+	if b.r == nil {
+		r, err := b.reader()
+		if err != nil {
+			return 0, err
+		}
+		b.r = r
+	}
+	return b.r.Read(p)
+}
+
+func (b *decompressBody) reader() (io.Reader, error) {
+	br := bufio.NewReader(b.ReadCloser)
+	if _, err := br.Peek(1); err != nil {
+		return nil, err
+	}
+
+	if b.encoding == EncodingGzip {
+		r, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("gzip response: %w", err)
+		}
+		return r, nil
+	}
+
+	// deflate 应该是 zlib 格式 也兼容部分服务端返回的原始 deflate
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		r, err := zlib.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("deflate response: %w", err)
+		}
+		return r, nil
+	}
+	return flate.NewReader(br), nil
+}
+
+func (b *decompressBody) Close() error {
+	if c, ok := b.r.(io.Closer); ok {
+		c.Close()
+	}
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newCompressServer echoes the decompressed request body, compressed with the encoding in query.
+func newCompressServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			body, _ = gzip.NewReader(r.Body)
+		case "deflate":
+			body, _ = zlib.NewReader(r.Body)
+		}
+		data, _ := ioutil.ReadAll(body)
+		w.Header().Set("X-Request-Encoding", r.Header.Get("Content-Encoding"))
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+
+		var out io.WriteCloser
+		encoding := r.URL.Query().Get("encoding")
+		switch encoding {
+		case "gzip":
+			out = gzip.NewWriter(w)
+		case "deflate":
+			out, _ = zlib.NewWriterLevel(w, zlib.DefaultCompression)
+		case "raw-deflate":
+			out, _ = flate.NewWriter(w, flate.DefaultCompression)
+		default:
+			w.Write(data)
+			return
+		}
+		w.Header().Set("Content-Encoding", strings.TrimPrefix(encoding, "raw-"))
+		out.Write(data)
+		out.Close()
+	}))
+}
+
+func TestCompress_Request(t *testing.T) {
+	ts := newCompressServer()
+	defer ts.Close()
+
+	payload := map[string]string{"data": strings.Repeat("a", 2048)}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		cli := NewClient(BaseURI(ts.URL), CompressRequest(CompressConfig{Encoding: encoding}))
+		resp, err := cli.Post("/", Options{JSON: payload})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := resp.GetBody()
+		if http.Header(resp.GetHeaders()).Get("X-Request-Encoding") != encoding || !strings.Contains(string(body), payload["data"]) {
+			t.Fatalf("%s: request body should be compressed, got %q", encoding, http.Header(resp.GetHeaders()).Get("X-Request-Encoding"))
+		}
+	}
+
+	// 日志打印压缩前的请求体
+	var dump bytes.Buffer
+	log.SetOutput(&dump)
+	NewClient(BaseURI(ts.URL), Debug(true), CompressRequest(CompressConfig{})).Post("/", Options{JSON: payload})
+	log.SetOutput(os.Stderr)
+	if !strings.Contains(dump.String(), payload["data"]) {
+		t.Fatalf("request dump should contain the uncompressed body:\n%s", dump.String())
+	}
+
+	// 小于 MinSize 不压缩
+	resp, _ := NewClient(BaseURI(ts.URL), CompressRequest(CompressConfig{})).Post("/", Options{JSON: map[string]string{"a": "b"}})
+	if http.Header(resp.GetHeaders()).Get("X-Request-Encoding") != "" {
+		t.Fatal("small body should not be compressed")
+	}
+}
+
+func TestCompress_Response(t *testing.T) {
+	ts := newCompressServer()
+	defer ts.Close()
+
+	cli := NewClient(BaseURI(ts.URL))
+	for _, encoding := range []string{"gzip", "deflate", "raw-deflate"} {
+		resp, err := cli.Post("/?encoding="+encoding, Options{FormParams: map[string]interface{}{"k": "v"}})
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		body, _ := resp.GetBody()
+		if string(body) != "k=v" || http.Header(resp.GetHeaders()).Get("Content-Encoding") != "" {
+			t.Fatalf("%s: response should be decompressed, got %q", encoding, body)
+		}
+		if http.Header(resp.GetHeaders()).Get("X-Accept-Encoding") != acceptEncoding {
+			t.Fatalf("Accept-Encoding should be declared, got %q", http.Header(resp.GetHeaders()).Get("X-Accept-Encoding"))
+		}
+	}
+
+	// 调用方自己设置 Accept-Encoding 时不解压
+	resp, err := cli.Post("/?encoding=gzip", Options{
+		FormParams: map[string]interface{}{"k": "v"},
+		Headers:    map[string]interface{}{"Accept-Encoding": "gzip"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := resp.GetBody()
+	if http.Header(resp.GetHeaders()).Get("Content-Encoding") != "gzip" || bytes.Equal(body, []byte("k=v")) {
+		t.Fatal("response should be kept as is when Accept-Encoding is set by caller")
+	}
+}
+
+func TestCompress_MaxResponseSize(t *testing.T) {
+	ts := newCompressServer()
+	defer ts.Close()
+
+	large := map[string]interface{}{"k": strings.Repeat("a", 4096)}
+	cli := NewClient(BaseURI(ts.URL), MaxResponseSize(1024))
+
+	for _, uri := range []string{"/", "/?encoding=gzip"} {
+		resp, err := cli.Post(uri, Options{FormParams: large})
+		if errorCode(err) != CodeResponseTooLarge || resp != nil {
+			t.Fatalf("%s: expect response too large, got %v", uri, err)
+		}
+	}
+
+	// 流式读取时 GetBody 同样受限制
+	resp, err := cli.Post("/", Options{FormParams: large, Stream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = resp.GetBody(); errorCode(err) != CodeResponseTooLarge {
+		t.Fatalf("expect response too large, got %v", err)
+	}
+
+	if _, err = cli.Post("/", Options{FormParams: map[string]interface{}{"k": "v"}}); err != nil {
+		t.Fatalf("small response should pass: %v", err)
+	}
+}
@@ -1,10 +1,15 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	urlpkg "net/url"
+	"strings"
 	"time"
 
 	"github.com/yulecd/pp-common/config"
+	"github.com/yulecd/pp-common/plog"
 )
 
 const (
@@ -12,40 +17,245 @@ const (
 )
 
 type Config struct {
-	Name    string            `json:"name" yaml:"name"`
-	BaseUri string            `json:"base_uri" yaml:"base_uri"`
-	Timeout int               `json:"timeout" yaml:"timeout"` // 超时时间 单位毫秒
-	Headers map[string]string `json:"headers" yaml:"headers"` // header 头
+	Name            string             `json:"name" yaml:"name"`
+	BaseUri         string             `json:"base_uri" yaml:"base_uri"`
+	Timeout         int                `json:"timeout" yaml:"timeout"`                     // 超时时间 单位毫秒
+	AttemptTimeout  int                `json:"attempt_timeout" yaml:"attempt_timeout"`     // 单次尝试超时时间 单位毫秒
+	Headers         map[string]string  `json:"headers" yaml:"headers"`                     // header 头
+	Breaker         *BreakerConfig     `json:"breaker" yaml:"breaker"`                     // 熔断配置 不配置则不启用
+	RetryBudget     *RetryBudgetConfig `json:"retry_budget" yaml:"retry_budget"`           // 重试预算 不配置则不限制
+	RateLimit       *RateLimitConfig   `json:"rate_limit" yaml:"rate_limit"`               // 本地限流 不配置则不限制
+	Bulkhead        *BulkheadConfig    `json:"bulkhead" yaml:"bulkhead"`                   // 并发隔离 不配置则不限制
+	Hedge           *HedgeConfig       `json:"hedge" yaml:"hedge"`                         // 对冲请求 不配置则不启用
+	Mirror          *MirrorConfig      `json:"mirror" yaml:"mirror"`                       // 流量镜像 不配置则不启用
+	Cache           *CacheConfig       `json:"cache" yaml:"cache"`                         // GET响应缓存 不配置则不缓存
+	Compress        *CompressConfig    `json:"compress" yaml:"compress"`                   // 请求体压缩 不配置则不压缩
+	MaxResponseSize int64              `json:"max_response_size" yaml:"max_response_size"` // 响应体最大字节数 超过返回 ErrResponseTooLarge 0 不限制
+	Envelope        *EnvelopeConfig    `json:"envelope" yaml:"envelope"`                   // 业务响应格式 配置后非成功的业务码返回 BusinessError
+	Sign            *SignConfig        `json:"sign" yaml:"sign"`                           // 请求签名 不配置则不签名
+	OAuth2          *OAuth2Config      `json:"oauth2" yaml:"oauth2"`                       // client credentials 获取token 不配置则不使用
+
+	Transport *TransportConfig `json:"transport" yaml:"transport"` // 连接池配置 不配置使用DefaultTransport
+
+	Endpoints   []EndpointConfig   `json:"endpoints" yaml:"endpoints"`       // 多个节点 配置后代替 base_uri
+	LoadBalance *LoadBalanceConfig `json:"load_balance" yaml:"load_balance"` // 多个节点的负载均衡配置
 }
 
-// NewHttpClientWithConfig 根据config配置初始化client
+// NewHttpClientWithConfig 根据config配置初始化client 配置有错误时记录日志 client 的所有请求都返回配置错误
+// 避免签名 认证等配置错误时不签名直接发出请求 需要配置修改后自动生效的 使用 Get 或 NewRegistry
 func NewHttpClientWithConfig(name string, service string, opt ...Option) *Request {
 	nOpt := make([]Option, 0, len(opt)+5)
+	nOpt = append(nOpt, ServiceName(service))
+	// 没有单独配置的服务也要透传路由标签
+	if router := getRouter(); router != nil {
+		nOpt = append(nOpt, WithRouter(router))
+	}
 
 	var cm map[string]Config
 	err := config.Load(name, &cm)
 	if err == nil {
 		if c, ok := cm[service]; ok {
-			nOpt = append(nOpt, func(options *Options) {
-				if len(c.BaseUri) > 0 {
-					options.BaseURI = c.BaseUri
-				}
-				if c.Timeout > 0 {
-					options.timeout = time.Duration(c.Timeout) * time.Microsecond
+			cerr := c.validate()
+			var cOpt []Option
+			if cerr == nil {
+				// 被替换的对象之前创建的client可能还在用 不在这里释放
+				tx := beginShared(true)
+				if cOpt, cerr = c.options(service); cerr != nil {
+					tx.rollback()
+				} else {
+					tx.commit()
 				}
-				if len(c.Headers) > 0 {
-					if options.Headers == nil {
-						options.Headers = make(map[string]interface{})
-					}
-					for key, value := range c.Headers {
-						options.Headers[key] = value
-					}
-				}
-			})
+			}
+			if cerr != nil {
+				cerr = fmt.Errorf("client %s config error: %w", service, cerr)
+				plog.Errorf(nil, "%v", cerr)
+				cOpt = []Option{Wrap(failWrapper(cerr))}
+			}
+			nOpt = append(nOpt, cOpt...)
 		}
 	} else {
-		fmt.Println("newHttpClientWithConfig error:", err)
+		plog.Errorf(nil, "client %s load config %s error: %v", service, name, err)
 	}
 	nOpt = append(nOpt, opt...)
 	return newHttpClient(nOpt...)
 }
+
+// validate checks the config without creating anything, certificate and key files are read.
+func (c *Config) validate() error {
+	if c.BaseUri != "" {
+		u, err := urlpkg.Parse(c.BaseUri)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid base_uri %q", c.BaseUri)
+		}
+	}
+	if c.Timeout < 0 || c.AttemptTimeout < 0 {
+		return fmt.Errorf("invalid timeout %d attempt_timeout %d", c.Timeout, c.AttemptTimeout)
+	}
+	if c.Compress != nil && c.Compress.Encoding != "" && c.Compress.Encoding != EncodingGzip && c.Compress.Encoding != EncodingDeflate {
+		return fmt.Errorf("invalid compress encoding %q", c.Compress.Encoding)
+	}
+	if len(c.Endpoints) > 0 {
+		if err := validateEndpoints(c.Endpoints); err != nil {
+			return err
+		}
+	}
+	if c.OAuth2 != nil {
+		if err := c.OAuth2.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Sign != nil {
+		if _, err := NewSigner(*c.Sign); err != nil {
+			return err
+		}
+	}
+	if c.Transport != nil && c.Transport.TLS != nil {
+		if err := c.Transport.TLS.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Transport != nil && c.Transport.Proxy != nil {
+		if err := c.Transport.Proxy.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Mirror != nil {
+		if err := c.Mirror.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// options returns options of the config, the error reports the parts which can not be applied,
+// the config should be validated first and the client must not be used if an error is returned.
+// Shared objects are taken in the transaction begun by the caller.
+func (c Config) options(service string) ([]Option, error) {
+	nOpt := make([]Option, 0, 8)
+	var errs []string
+
+	if c.Transport != nil {
+		nOpt = append(nOpt, Transport(getServiceTransport(service, *c.Transport)))
+	}
+	if len(c.Endpoints) > 0 {
+		pool, perr := getServiceEndpointPool(service, c.Endpoints, c.LoadBalance, c.Transport)
+		if perr != nil {
+			errs = append(errs, perr.Error())
+		} else {
+			nOpt = append(nOpt, WithEndpointPool(pool))
+		}
+	}
+	// 缓存在最外层 命中缓存的请求不受限流影响
+	if c.Cache != nil {
+		cache := getShared("cache", service, *c.Cache, func() interface{} {
+			return NewCache(*c.Cache)
+		}).(*Cache)
+		nOpt = append(nOpt, Wrap(cache.Wrapper))
+	}
+	// 限流和并发隔离在熔断之外 被拒绝的请求不计入熔断统计
+	if c.RateLimit != nil {
+		limiter := getShared("rate_limit", service, *c.RateLimit, func() interface{} {
+			return NewRateLimiter(*c.RateLimit)
+		}).(*RateLimiter)
+		nOpt = append(nOpt, Wrap(limiter.Wrapper))
+	}
+	if c.Bulkhead != nil {
+		bulkhead := getShared("bulkhead", service, *c.Bulkhead, func() interface{} {
+			return NewBulkhead(*c.Bulkhead)
+		}).(*Bulkhead)
+		nOpt = append(nOpt, Wrap(bulkhead.Wrapper))
+	}
+	// 镜像在对冲和重试之外 只复制最终发出的请求
+	if c.Mirror != nil {
+		mirror, merr := getServiceMirror(service, *c.Mirror)
+		if merr != nil {
+			errs = append(errs, merr.Error())
+		} else {
+			nOpt = append(nOpt, Wrap(mirror.Wrapper))
+		}
+	}
+	// 对冲在熔断之外 每一路请求都经过熔断 按各自的host统计
+	if c.Hedge != nil {
+		hedger := getShared("hedge", service, *c.Hedge, func() interface{} {
+			return NewHedger(*c.Hedge)
+		}).(*Hedger)
+		nOpt = append(nOpt, Wrap(hedger.Wrapper))
+	}
+	if c.Breaker != nil {
+		cb := getShared("breaker", service, *c.Breaker, func() interface{} {
+			return NewCircuitBreaker(*c.Breaker)
+		}).(*CircuitBreaker)
+		nOpt = append(nOpt, Wrap(cb.Wrapper))
+	}
+	if c.RetryBudget != nil {
+		budget := getShared("retry_budget", service, *c.RetryBudget, func() interface{} {
+			return NewRetryBudget(*c.RetryBudget)
+		}).(*RetryBudget)
+		nOpt = append(nOpt, WithRetryBudget(budget))
+	}
+	if c.OAuth2 != nil {
+		source, oerr := getServiceTokenSource(service, *c.OAuth2)
+		if oerr != nil {
+			errs = append(errs, oerr.Error())
+		} else {
+			nOpt = append(nOpt, Wrap(source.Wrapper))
+		}
+	}
+	if c.Sign != nil {
+		signer, serr := NewSigner(*c.Sign)
+		if serr != nil {
+			errs = append(errs, serr.Error())
+		} else {
+			nOpt = append(nOpt, Wrap(signer.Wrapper))
+		}
+	}
+	// 故障注入在最内层 熔断和对冲可以感知到注入的故障
+	if injector := getFaultInjector(); injector != nil {
+		nOpt = append(nOpt, Wrap(injector.Wrapper))
+	}
+	if c.Compress != nil {
+		nOpt = append(nOpt, CompressRequest(*c.Compress))
+	}
+	if c.Envelope != nil {
+		nOpt = append(nOpt, WithEnvelope(*c.Envelope))
+		if len(c.Envelope.RetryCodes) > 0 {
+			nOpt = append(nOpt, Retry(RetryOnBusinessCode(DefaultRetry, c.Envelope.RetryCodes...)))
+		}
+	}
+	nOpt = append(nOpt, func(options *Options) {
+		if len(c.BaseUri) > 0 {
+			options.BaseURI = c.BaseUri
+		}
+		if c.Timeout > 0 {
+			options.timeout = time.Duration(c.Timeout) * time.Millisecond
+		}
+		if c.AttemptTimeout > 0 {
+			options.attemptTimeout = time.Duration(c.AttemptTimeout) * time.Millisecond
+		}
+		if c.MaxResponseSize > 0 {
+			options.maxRespSize = c.MaxResponseSize
+		}
+		if len(c.Headers) > 0 {
+			if options.Headers == nil {
+				options.Headers = make(map[string]interface{})
+			}
+			for key, value := range c.Headers {
+				options.Headers[key] = value
+			}
+		}
+	})
+
+	if len(errs) > 0 {
+		return nOpt, errors.New(strings.Join(errs, "; "))
+	}
+	return nOpt, nil
+}
+
+// failWrapper fails every call with err, it is used when the config is invalid.
+func failWrapper(err error) WrapperChain {
+	return func(next Wrapper) Wrapper {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package client
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DownloadOptions 下载选项
+type DownloadOptions struct {
+	Options                                   // 单次请求选项 如 Query Headers
+	Timeout     time.Duration                 // 整个下载的超时时间 0 只受上下文控制
+	Retries     int                           // 传输中断后续传的次数 默认3
+	Checksum    string                        // 下载完成后校验 格式 算法:hex 支持 md5 sha1 sha256 sha512
+	MaxSize     int64                         // 文件最大字节数 0不限制
+	Concurrency int                           // 大于1且服务端支持Range时分块并发下载
+	ChunkSize   int64                         // 分块大小 默认8MB
+	Progress    func(total, downloaded int64) // 进度回调 总大小未知时total为-1
+}
+
+func (o *DownloadOptions) checkConf() {
+	if o.Retries <= 0 {
+		o.Retries = 3
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 1
+	}
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = 8 << 20
+	}
+}
+
+// Download downloads uri to localPath with DefaultClient, see Request.Download.
+func Download(ctx context.Context, uri, localPath string, opts ...DownloadOptions) error {
+	return DefaultClient.WithContext(ctx).Download(uri, localPath, opts...)
+}
+
+// Download streams uri into localPath, the content is written to localPath.download first
+// and renamed after it is completed and verified. An existing .download file is resumed with
+// a Range and If-Range request, so a failed download can be continued by calling Download again.
+// The ETag or Last-Modified for If-Range is kept in localPath.download.validator, a partial file
+// without it is downloaded again from the start.
+// The client timeout does not apply, use DownloadOptions.Timeout or the context instead.
+func (r *Request) Download(uri, localPath string, opts ...DownloadOptions) error {
+	if r.client == nil {
+		return errors.New("invalid request: no client")
+	}
+
+	var o DownloadOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	o.checkConf()
+
+	sum, err := parseChecksum(o.Checksum)
+	if err != nil {
+		return err
+	}
+
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+
+	tmp := localPath + ".download"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset := info.Size()
+	validatorPath := tmp + ".validator"
+	validator := ""
+	if offset > 0 {
+		// 不知道已下载部分对应的文件版本 无法保证续传的内容一致 从头下载
+		content, rerr := ioutil.ReadFile(validatorPath)
+		if validator = strings.TrimSpace(string(content)); rerr != nil || validator == "" {
+			offset, validator = 0, ""
+		}
+	}
+
+	d := &downloader{
+		// 下载可能很久 不使用client的整体超时和单次超时
+		req: r.withOptions(func(opts *Options) {
+			opts.timeout = 0
+			opts.attemptTimeout = 0
+		}),
+		ctx:           ctx,
+		uri:           uri,
+		opts:          o,
+		file:          f,
+		total:         -1,
+		validator:     validator,
+		validatorPath: validatorPath,
+	}
+
+	size, err := d.run(offset)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded && (r.ctx == nil || r.ctx.Err() == nil) {
+			return ErrTimeout.Wrap(err.Error())
+		}
+		return err
+	}
+
+	if err = f.Truncate(size); err != nil {
+		return err
+	}
+	if sum != nil {
+		if err = sum.verify(f); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			os.Remove(validatorPath)
+			return err
+		}
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	os.Remove(validatorPath)
+
+	return os.Rename(tmp, localPath)
+}
+
+// withOptions returns a copy of r whose client options are changed by fn, the http client is shared.
+func (r *Request) withOptions(fn func(o *Options)) *Request {
+	c := &HttpClient{
+		opts: r.client.opts.clone(),
+		cli:  r.client.cli,
+	}
+	fn(&c.opts)
+
+	return c.WithContext(r.ctx)
+}
+
+type downloader struct {
+	req  *Request
+	ctx  context.Context
+	uri  string
+	opts DownloadOptions
+	file *os.File
+
+	total         int64  // 文件总大小 -1未知
+	validator     string // ETag 或 Last-Modified 续传时用 If-Range 保证文件没有变化
+	validatorPath string // validator 保存的位置 再次调用 Download 续传时使用
+	downloaded    int64
+	progressMu    sync.Mutex
+}
+
+// run downloads the file starting at offset and returns the final file size.
+func (d *downloader) run(offset int64) (int64, error) {
+	atomic.StoreInt64(&d.downloaded, offset)
+
+	if d.opts.Concurrency <= 1 || offset > 0 {
+		return d.fetchRetry(d.ctx, offset, -1)
+	}
+
+	// 第一个分块同时用来获取文件大小和确认服务端是否支持Range
+	pos, err := d.fetchRetry(d.ctx, 0, d.opts.ChunkSize-1)
+	if err != nil || d.total < 0 || pos >= d.total {
+		return pos, err
+	}
+
+	return d.total, d.parallel(d.opts.ChunkSize)
+}
+
+// parallel fetches chunks from offset to the end with Concurrency workers.
+func (d *downloader) parallel(offset int64) error {
+	ctx, cancel := context.WithCancel(d.ctx)
+	defer cancel()
+
+	total := atomic.LoadInt64(&d.total)
+
+	chunks := make(chan int64)
+	go func() {
+		defer close(chunks)
+		for start := offset; start < total; start += d.opts.ChunkSize {
+			select {
+			case chunks <- start:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := 0; i < d.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range chunks {
+				end := start + d.opts.ChunkSize - 1
+				if end >= total {
+					end = total - 1
+				}
+				if _, err := d.fetchRetry(ctx, start, end); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// fetchRetry fetches the range and resumes from the last written byte when transfer breaks.
+func (d *downloader) fetchRetry(ctx context.Context, start, end int64) (int64, error) {
+	for i := 0; ; i++ {
+		pos, err := d.fetch(ctx, start, end)
+		if err == nil {
+			return pos, nil
+		}
+
+		var fatal *downloadFatalError
+		if errors.As(err, &fatal) {
+			return pos, fatal.err
+		}
+		if ctx.Err() != nil || i >= d.opts.Retries {
+			return pos, err
+		}
+		start = pos
+	}
+}
+
+// downloadFatalError can not be resolved by resuming
+type downloadFatalError struct {
+	err error
+}
+
+func (e *downloadFatalError) Error() string {
+	return e.err.Error()
+}
+
+// fetch requests bytes from start to end, end < 0 means to the end of file.
+// The bytes are written at their offsets and the position after the last written byte is returned.
+func (d *downloader) fetch(ctx context.Context, start, end int64) (int64, error) {
+	opts := d.opts.Options
+	headers := make(map[string]interface{}, len(opts.Headers)+3)
+	for k, v := range opts.Headers {
+		headers[k] = v
+	}
+	// 压缩后的内容无法按偏移续传
+	headers["Accept-Encoding"] = "identity"
+	ranged := start > 0 || end >= 0
+	if ranged {
+		if end >= 0 {
+			headers["Range"] = fmt.Sprintf("bytes=%d-%d", start, end)
+		} else {
+			headers["Range"] = fmt.Sprintf("bytes=%d-", start)
+		}
+		if d.validator != "" {
+			headers["If-Range"] = d.validator
+		}
+	}
+	opts.Headers = headers
+	opts.Stream = true
+
+	resp, err := d.req.WithContext(ctx).Get(d.uri, opts)
+	if err != nil {
+		return start, err
+	}
+	hresp := resp.hresp
+
+	switch {
+	case hresp.StatusCode == http.StatusPartialContent && ranged:
+		first, total, ok := parseContentRange(hresp.Header.Get("Content-Range"))
+		if !ok || first != start {
+			resp.discard()
+			return start, &downloadFatalError{fmt.Errorf("download unexpected content range %q", hresp.Header.Get("Content-Range"))}
+		}
+		if total >= 0 {
+			atomic.StoreInt64(&d.total, total)
+		}
+	case hresp.StatusCode == http.StatusOK:
+		if start > 0 && end >= 0 {
+			// 并发分块时文件发生了变化
+			resp.discard()
+			return start, &downloadFatalError{errors.New("download file changed during chunked fetching")}
+		}
+		// 服务端不支持Range或者文件已变化 从头下载 使用新文件的 validator
+		if start > 0 {
+			atomic.AddInt64(&d.downloaded, -start)
+			d.validator = ""
+		}
+		start, end = 0, -1
+		atomic.StoreInt64(&d.total, hresp.ContentLength)
+	case hresp.StatusCode == http.StatusRequestedRangeNotSatisfiable && start > 0:
+		resp.discard()
+		_, total, ok := parseContentRange(hresp.Header.Get("Content-Range"))
+		if ok && total == start {
+			// 上次已经下载完成
+			atomic.StoreInt64(&d.total, total)
+			return start, nil
+		}
+		atomic.AddInt64(&d.downloaded, -start)
+		return 0, errors.New("download range not satisfiable, restart")
+	default:
+		resp.discard()
+		return start, &downloadFatalError{fmt.Errorf("download %s: unexpected status %s", d.uri, hresp.Status)}
+	}
+
+	// 只有第一次响应设置 并发分块时只读
+	if d.validator == "" && (start == 0 || end < 0) {
+		if etag := hresp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			d.validator = etag
+		} else {
+			d.validator = hresp.Header.Get("Last-Modified")
+		}
+		// 写入失败时下次调用只能从头下载
+		if d.validator != "" {
+			ioutil.WriteFile(d.validatorPath, []byte(d.validator), 0644)
+		} else {
+			os.Remove(d.validatorPath)
+		}
+	}
+
+	total := atomic.LoadInt64(&d.total)
+	limit := int64(0)
+	if d.opts.MaxSize > 0 {
+		if total > d.opts.MaxSize {
+			resp.discard()
+			return start, &downloadFatalError{ErrResponseTooLarge.Wrap(fmt.Sprintf("file size %d exceeds %d", total, d.opts.MaxSize))}
+		}
+		limit = d.opts.MaxSize - start
+	}
+	body, err := resp.BodyReader(limit)
+	if err != nil {
+		return start, &downloadFatalError{err}
+	}
+	defer body.Close()
+
+	n, err := io.Copy(&offsetWriter{d: d, pos: start}, body)
+	pos := start + n
+	if err != nil {
+		if errorCode(err) == CodeResponseTooLarge {
+			return pos, &downloadFatalError{err}
+		}
+		return pos, err
+	}
+
+	expect := total
+	if end >= 0 {
+		expect = end + 1
+	}
+	if expect >= 0 && pos < expect {
+		return pos, io.ErrUnexpectedEOF
+	}
+
+	return pos, nil
+}
+
+func (d *downloader) progress(n int64) {
+	downloaded := atomic.AddInt64(&d.downloaded, n)
+	if d.opts.Progress == nil {
+		return
+	}
+
+	// 并发下载时回调串行执行
+	d.progressMu.Lock()
+	defer d.progressMu.Unlock()
+	d.opts.Progress(atomic.LoadInt64(&d.total), downloaded)
+}
+
+// offsetWriter writes to the file at increasing offsets and reports progress
+type offsetWriter struct {
+	d   *downloader
+	pos int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.d.file.WriteAt(p, w.pos)
+	w.pos += int64(n)
+	if n > 0 {
+		w.d.progress(int64(n))
+	}
+	return n, err
+}
+
+// parseContentRange parses "bytes first-last/total" and "bytes */total", total is -1 if unknown.
+func parseContentRange(value string) (first, total int64, ok bool) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "bytes ") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, "bytes "), "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	total = -1
+	if parts[1] != "*" {
+		t, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		total = t
+	}
+
+	if parts[0] == "*" {
+		return 0, total, true
+	}
+	bounds := strings.SplitN(parts[0], "-", 2)
+	first, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return first, total, true
+}
+
+type checksum struct {
+	algo   string
+	expect string
+	hash   func() hash.Hash
+}
+
+// parseChecksum parses "algo:hex", nil is returned for empty value.
+func parseChecksum(value string) (*checksum, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid checksum %q, expect algo:hex", value)
+	}
+
+	c := &checksum{algo: strings.ToLower(parts[0]), expect: strings.ToLower(parts[1])}
+	switch c.algo {
+	case "md5":
+		c.hash = md5.New
+	case "sha1":
+		c.hash = sha1.New
+	case "sha256":
+		c.hash = sha256.New
+	case "sha512":
+		c.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", parts[0])
+	}
+
+	return c, nil
+}
+
+func (c *checksum) verify(f *os.File) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := c.hash()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != c.expect {
+		return fmt.Errorf("download %s checksum mismatch: expect %s, got %s", c.algo, c.expect, got)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFileServer serves content with Range support, the first `breaks` full requests
+// are cut off in the middle of the body.
+func newFileServer(content []byte, breaks int32) (*httptest.Server, *sync.Map) {
+	var full int32
+	ranges := &sync.Map{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges.Store(r.Header.Get("Range"), true)
+		if v := r.Header.Get("If-Range"); v != "" {
+			ranges.Store("If-Range "+v, true)
+		}
+		if r.Header.Get("Range") == "" && atomic.AddInt32(&full, 1) <= breaks {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(content[:len(content)/2])
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	})), ranges
+}
+
+func randomContent(n int) []byte {
+	content := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(content)
+	return content
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestDownload_Resume(t *testing.T) {
+	content := randomContent(64 << 10)
+	ts, ranges := newFileServer(content, 1)
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	var last int64
+	err := Download(context.Background(), ts.URL, path, DownloadOptions{
+		Checksum: sha256Hex(content),
+		Progress: func(total, downloaded int64) {
+			if total != int64(len(content)) || downloaded < last {
+				t.Errorf("unexpected progress %d/%d", downloaded, total)
+			}
+			last = downloaded
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadFile(path)
+	if !bytes.Equal(got, content) || last != int64(len(content)) {
+		t.Fatalf("downloaded %d bytes, progress %d, expect %d", len(got), last, len(content))
+	}
+	if _, ok := ranges.Load("bytes=32768-"); !ok {
+		t.Fatal("broken transfer should be resumed from the written offset")
+	}
+
+	// 已有的 .download 文件带上保存的 validator 继续下载
+	path = filepath.Join(t.TempDir(), "file")
+	ioutil.WriteFile(path+".download", content[:1000], 0644)
+	ioutil.WriteFile(path+".download.validator", []byte(`"v1"`), 0644)
+	if err = NewClient().Download(ts.URL, path, DownloadOptions{Checksum: sha256Hex(content)}); err != nil {
+		t.Fatal(err)
+	}
+	_, resumed := ranges.Load("bytes=1000-")
+	_, validated := ranges.Load(`If-Range "v1"`)
+	if !resumed || !validated {
+		t.Fatal("existing partial file should be resumed with If-Range")
+	}
+	for _, tmp := range []string{".download", ".download.validator"} {
+		if _, err = os.Stat(path + tmp); !os.IsNotExist(err) {
+			t.Fatalf("temp file %s should be removed", tmp)
+		}
+	}
+
+	// 不知道版本的 .download 文件从头下载
+	path = filepath.Join(t.TempDir(), "file")
+	ioutil.WriteFile(path+".download", []byte("stale content of another version"), 0644)
+	if err = NewClient().Download(ts.URL, path, DownloadOptions{Checksum: sha256Hex(content)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ranges.Load("bytes=32-"); ok {
+		t.Fatal("partial file without validator should not be resumed")
+	}
+}
+
+func TestDownload_Parallel(t *testing.T) {
+	content := randomContent(10000)
+	ts, ranges := newFileServer(content, 0)
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	err := NewClient().Download(ts.URL, path, DownloadOptions{Concurrency: 4, ChunkSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadFile(path)
+	if !bytes.Equal(got, content) {
+		t.Fatalf("parallel download content mismatch, got %d bytes", len(got))
+	}
+	for _, r := range []string{"bytes=0-999", "bytes=5000-5999", "bytes=9000-9999"} {
+		if _, ok := ranges.Load(r); !ok {
+			t.Fatalf("chunk %s not requested", r)
+		}
+	}
+}
+
+func TestDownload_Verify(t *testing.T) {
+	content := randomContent(1000)
+	ts, _ := newFileServer(content, 0)
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := Download(context.Background(), ts.URL, path, DownloadOptions{Checksum: sha256Hex([]byte("other"))}); err == nil {
+		t.Fatal("checksum mismatch should fail")
+	}
+	if _, err := os.Stat(path + ".download"); !os.IsNotExist(err) {
+		t.Fatal("corrupted temp file should be removed")
+	}
+
+	err := Download(context.Background(), ts.URL, path, DownloadOptions{MaxSize: 999})
+	if errorCode(err) != CodeResponseTooLarge {
+		t.Fatalf("expect too large, got %v", err)
+	}
+}
+
+func TestResponse_BodyReader(t *testing.T) {
+	ts := newEchoServer()
+	defer ts.Close()
+
+	resp, err := NewClient().Get(ts.URL + "/0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := resp.BodyReader(5)
+	if errorCode(err) != CodeResponseTooLarge {
+		t.Fatalf("content length over limit should fail, got %v", err)
+	}
+
+	resp, _ = NewClient().Get(ts.URL + "/0123456789")
+	resp.hresp.ContentLength = -1
+	body, err = resp.BodyReader(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if _, err = ioutil.ReadAll(body); errorCode(err) != CodeResponseTooLarge {
+		t.Fatalf("streaming over limit should fail, got %v", err)
+	}
+}
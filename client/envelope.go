@@ -0,0 +1,185 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// EnvelopeConfig 业务响应格式 {code, data, message} 配置后 code 不是成功码的响应返回 *BusinessError
+type EnvelopeConfig struct {
+	CodeField    string   `json:"code_field" yaml:"code_field"`       // 默认 code
+	MessageField string   `json:"message_field" yaml:"message_field"` // 默认 message
+	DataField    string   `json:"data_field" yaml:"data_field"`       // 默认 data
+	SuccessCodes []string `json:"success_codes" yaml:"success_codes"` // 成功的业务码 数字和字符串都按字符串比较 默认 1
+	RetryCodes   []string `json:"retry_codes" yaml:"retry_codes"`     // 需要重试的业务码 如系统繁忙 只在配置文件里使用
+}
+
+func (c *EnvelopeConfig) checkConf() {
+	if c.CodeField == "" {
+		c.CodeField = "code"
+	}
+	if c.MessageField == "" {
+		c.MessageField = "message"
+	}
+	if c.DataField == "" {
+		c.DataField = "data"
+	}
+	if len(c.SuccessCodes) == 0 {
+		c.SuccessCodes = []string{strconv.Itoa(SuccessCode)}
+	}
+}
+
+func (c *EnvelopeConfig) success(code string) bool {
+	for _, s := range c.SuccessCodes {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Envelope 解析后的业务响应
+type Envelope struct {
+	Code    string          // 业务码 数字转成字符串
+	Message string          // 业务信息
+	Data    json.RawMessage // 业务数据 没有时为空
+	Success bool            // 是否成功
+}
+
+// BusinessError 上游返回的业务错误 错误码固定为 CodeBusiness 上游业务码见 UpstreamCode
+type BusinessError struct {
+	Service      string // 配置的服务名 见 ServiceName
+	UpstreamCode string // 上游原始业务码
+	Message      string
+}
+
+func (e *BusinessError) Error() string {
+	service := e.Service
+	if service == "" {
+		service = "upstream"
+	}
+	return fmt.Sprintf("%s business error: %s %s", service, e.UpstreamCode, e.Message)
+}
+
+// Code returns CodeBusiness, upstream codes may collide with the codes of this package.
+func (e *BusinessError) Code() int {
+	return CodeBusiness
+}
+
+func (e *BusinessError) Is(err error) bool {
+	_, ok := err.(*BusinessError)
+	return ok
+}
+
+// Envelope parses the business envelope of the buffered body with the configured field names,
+// nil is returned if the body is not a json object with the code field.
+func (r *Response) Envelope() (*Envelope, error) {
+	if r.envelope != nil || r.envelopeErr != nil {
+		return r.envelope, r.envelopeErr
+	}
+
+	conf := EnvelopeConfig{}
+	if r.req != nil && r.req.opts.envelope != nil {
+		conf = *r.req.opts.envelope
+	}
+	conf.checkConf()
+
+	body, err := r.GetBody()
+	if err != nil {
+		r.envelopeErr = err
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if jsoniter.Unmarshal(body, &fields) != nil {
+		return nil, nil
+	}
+	code, ok := fields[conf.CodeField]
+	if !ok {
+		return nil, nil
+	}
+
+	env := &Envelope{
+		Code: rawString(code),
+		Data: fields[conf.DataField],
+	}
+	if msg, ok := fields[conf.MessageField]; ok {
+		env.Message = rawString(msg)
+	}
+	env.Success = conf.success(env.Code)
+	r.envelope = env
+
+	return env, nil
+}
+
+// BusinessError returns *BusinessError if the envelope code is not a success code.
+func (r *Response) BusinessError() error {
+	env, err := r.Envelope()
+	if err != nil || env == nil || env.Success {
+		return nil
+	}
+
+	service := ""
+	if r.req != nil {
+		service = r.req.opts.service
+	}
+	return &BusinessError{
+		Service:      service,
+		UpstreamCode: env.Code,
+		Message:      env.Message,
+	}
+}
+
+// ParseData unmarshals data of the envelope into obj, *BusinessError is returned for non-success codes.
+func (r *Response) ParseData(obj interface{}) error {
+	if err := r.BusinessError(); err != nil {
+		return err
+	}
+	env, err := r.Envelope()
+	if err != nil {
+		return err
+	}
+	if env == nil {
+		return fmt.Errorf("ParseData invalid envelope: http status %d", r.GetStatusCode())
+	}
+	if len(env.Data) == 0 || obj == nil {
+		return nil
+	}
+	if err = jsoniter.Unmarshal(env.Data, obj); err != nil {
+		return fmt.Errorf("ParseData unmarshal data error: %w", err)
+	}
+	return nil
+}
+
+// RetryOnBusinessCode retries responses whose envelope code is one of codes,
+// other responses and errors are decided by fallback.
+func RetryOnBusinessCode(fallback RetryFunc, codes ...string) RetryFunc {
+	return func(ctx context.Context, req *Request, resp *Response, retryCount int, err error) (bool, error) {
+		if err == nil && resp != nil && resp.hresp != nil {
+			if env, _ := resp.Envelope(); env != nil {
+				for _, code := range codes {
+					if env.Code == code {
+						return true, nil
+					}
+				}
+			}
+		}
+		return fallback(ctx, req, resp, retryCount, err)
+	}
+}
+
+// rawString returns the string value or the raw text of a json value.
+func rawString(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	var s string
+	if jsoniter.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return strings.Trim(string(raw), `"`)
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEnvelope_BusinessError(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/busy":
+			// 前两次系统繁忙
+			if atomic.AddInt32(&calls, 1) <= 2 {
+				w.Write([]byte(`{"code":"SYSTEM_BUSY","message":"busy"}`))
+				return
+			}
+			w.Write([]byte(`{"code":"SUCCESS","data":{"id":7}}`))
+		case "/fail":
+			w.Write([]byte(`{"code":40001,"message":"invalid order"}`))
+		default:
+			w.Write([]byte(`plain text`))
+		}
+	}))
+	defer ts.Close()
+
+	cli := NewClient(BaseURI(ts.URL), ServiceName("partner"),
+		WithEnvelope(EnvelopeConfig{SuccessCodes: []string{"SUCCESS"}}),
+		Retry(RetryOnBusinessCode(RetryOnError, "SYSTEM_BUSY")), Retries(3), Backoff(ConstantBackoff(0)))
+
+	resp, err := cli.Get("/busy")
+	if err != nil {
+		t.Fatalf("busy code should be retried: %v", err)
+	}
+	var data struct {
+		ID int `json:"id"`
+	}
+	if err = resp.ParseData(&data); err != nil || data.ID != 7 || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("unexpected data %+v after %d calls: %v", data, calls, err)
+	}
+
+	resp, err = cli.Get("/fail")
+	var berr *BusinessError
+	if !errors.As(err, &berr) || resp == nil {
+		t.Fatalf("expect business error with response, got %v", err)
+	}
+	if berr.Service != "partner" || berr.UpstreamCode != "40001" || errorCode(err) != CodeBusiness || berr.Message != "invalid order" {
+		t.Fatalf("unexpected business error %+v", berr)
+	}
+
+	// 不是业务格式的响应不检查
+	if _, err = cli.Get("/plain"); err != nil {
+		t.Fatalf("non envelope response should pass: %v", err)
+	}
+}
+
+func TestEnvelope_Fields(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok","result":["a","b"]}`))
+	}))
+	defer ts.Close()
+
+	conf := EnvelopeConfig{CodeField: "errcode", MessageField: "errmsg", DataField: "result", SuccessCodes: []string{"0"}}
+	resp, err := NewClient(BaseURI(ts.URL), WithEnvelope(conf)).Get("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []string
+	if err = resp.ParseData(&list); err != nil || len(list) != 2 {
+		t.Fatalf("unexpected data %v: %v", list, err)
+	}
+
+	// 默认成功码是 1
+	resp, err = NewClient(BaseURI(ts.URL), WithEnvelope(EnvelopeConfig{CodeField: "errcode"})).Get("/")
+	if berr, ok := err.(*BusinessError); !ok || berr.UpstreamCode != "0" || resp.BusinessError() == nil {
+		t.Fatalf("code 0 should fail with default success code: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/yulecd/pp-common/perrors"
+)
+
+// client 组件错误码 统一使用 51xxx 段
+const (
+	CodeCircuitOpen = 51001
+	CodeTimeout     = 51002 // 上游超时 整体超时或单次尝试超时
+	CodeCanceled    = 51003 // 调用方取消 上下文被取消或调用方的deadline到期
+
+	CodeRetryBudgetExhausted = 51004
+	CodeRateLimited          = 51005
+	CodeBulkheadFull         = 51006
+	CodeCassetteMiss         = 51007
+	CodeResponseTooLarge     = 51008
+	CodeBusiness             = 51009 // 上游返回非成功业务码 见 BusinessError.UpstreamCode
+)
+
+var (
+	ErrCircuitOpen = perrors.NewError(CodeCircuitOpen, "circuit breaker is open")
+	ErrTimeout     = perrors.NewError(CodeTimeout, "upstream timeout")
+	ErrCanceled    = perrors.NewError(CodeCanceled, "request canceled by caller")
+
+	ErrRetryBudgetExhausted = perrors.NewError(CodeRetryBudgetExhausted, "retry budget exhausted")
+	ErrRateLimited          = perrors.NewError(CodeRateLimited, "client rate limited")
+	ErrBulkheadFull         = perrors.NewError(CodeBulkheadFull, "client bulkhead full")
+	ErrCassetteMiss         = perrors.NewError(CodeCassetteMiss, "no recorded response matches request")
+	ErrResponseTooLarge     = perrors.NewError(CodeResponseTooLarge, "response body too large")
+)
+
+// errorCode returns code of a perrors error, 0 if err has no code.
+func errorCode(err error) int {
+	var e perrors.CustomError
+	if errors.As(err, &e) {
+		return e.Code()
+	}
+	return 0
+}
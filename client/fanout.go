@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/yulecd/pp-common/plog"
+)
+
+// FanOutConfig 并发调用配置
+type FanOutConfig struct {
+	Concurrency int           `json:"concurrency" yaml:"concurrency"` // 最大并发数 默认 10
+	Timeout     time.Duration `json:"timeout" yaml:"timeout"`         // 所有调用共享的截止时间 0 只使用上下文的截止时间
+	FailFast    bool          `json:"fail_fast" yaml:"fail_fast"`     // 有调用失败时取消其他调用 未开始的调用不再执行
+}
+
+func (c *FanOutConfig) checkConf() {
+	if c.Concurrency <= 0 {
+		c.Concurrency = 10
+	}
+}
+
+// Call 并发调用中的一次调用 需要使用传入的上下文发送请求 如 cli.WithContext(ctx).Get(uri)
+type Call func(ctx context.Context) (*Response, error)
+
+// Result 一次调用的结果
+type Result struct {
+	Resp *Response
+	Err  error
+}
+
+// FanOut runs calls concurrently with conf and returns their results in input order.
+// Calls share the deadline of conf.Timeout and the trace and log entry of ctx, so ctx should be
+// the context of the incoming request. The context is canceled when FanOut returns, streamed
+// responses must be read inside the call.
+// The returned error is the first error in input order, or the one which canceled the others in fail-fast mode.
+func FanOut(ctx context.Context, conf FanOutConfig, calls ...Call) ([]Result, error) {
+	conf.checkConf()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// 先在入口请求上生成日志对象和 trace 所有调用共享 避免并发生成不同的 trace
+	log := plog.GetDefaultFieldEntry(ctx)
+
+	var cancel context.CancelFunc
+	if conf.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, conf.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	results := make([]Result, len(calls))
+	var (
+		once     sync.Once
+		firstErr error
+	)
+	index := make(chan int)
+	wg := sync.WaitGroup{}
+
+	workers := conf.Concurrency
+	if workers > len(calls) {
+		workers = len(calls)
+	}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range index {
+				if err := ctx.Err(); err != nil {
+					results[i].Err = ErrCanceled.Wrap(fmt.Sprintf("fan-out call %d not started: %v", i, err))
+					continue
+				}
+
+				results[i].Resp, results[i].Err = runCall(ctx, i, calls[i])
+				if results[i].Err != nil && conf.FailFast {
+					once.Do(func() {
+						firstErr = results[i].Err
+						cancel()
+						if log != nil {
+							log.Warnf("client fan-out failed fast at call %d: %v", i, firstErr)
+						}
+					})
+				}
+			}
+		}()
+	}
+	for i := range calls {
+		index <- i
+	}
+	close(index)
+	wg.Wait()
+
+	if firstErr != nil {
+		return results, firstErr
+	}
+	for _, r := range results {
+		if r.Err != nil {
+			return results, r.Err
+		}
+	}
+	return results, nil
+}
+
+// runCall runs call and turns its panic into error, one call should not crash the others.
+func runCall(ctx context.Context, i int, call Call) (resp *Response, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			plog.Errorf(ctx, "client fan-out call %d panic: %v\n%s", i, r, debug.Stack())
+			resp, err = nil, fmt.Errorf("fan-out call %d panic: %v", i, r)
+		}
+	}()
+	return call(ctx)
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yulecd/pp-common/plog"
+)
+
+func TestFanOut_Results(t *testing.T) {
+	var inflight, peak int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	cli := NewClient(BaseURI(ts.URL), Retries(1))
+	boom := errors.New("boom")
+	ctx := incomingContext(nil)
+	trace := plog.GetDefaultFieldEntry(ctx).Data["trace"]
+
+	calls := make([]Call, 0, 8)
+	for i := 0; i < 8; i++ {
+		i, uri := i, "/"+strconv.Itoa(i)
+		calls = append(calls, func(ctx context.Context) (*Response, error) {
+			if i == 5 {
+				return nil, boom
+			}
+			if plog.GetDefaultFieldEntry(ctx).Data["trace"] != trace {
+				return nil, errors.New("trace should be propagated")
+			}
+			return cli.WithContext(ctx).Get(uri)
+		})
+	}
+
+	results, err := FanOut(ctx, FanOutConfig{Concurrency: 3}, calls...)
+	if err != boom || results[5].Err != boom {
+		t.Fatalf("expect error of call 5, got %v", err)
+	}
+	for i, r := range results {
+		if i == 5 {
+			continue
+		}
+		if r.Err != nil {
+			t.Fatalf("call %d: %v", i, r.Err)
+		}
+		if body, _ := r.Resp.GetBody(); string(body) != "/"+strconv.Itoa(i) {
+			t.Fatalf("result %d out of order: %s", i, body)
+		}
+	}
+	if p := atomic.LoadInt32(&peak); p != 3 {
+		t.Fatalf("concurrency should be limited to 3, peak %d", p)
+	}
+}
+
+func TestFanOut_FailFast(t *testing.T) {
+	ts := newSlowServer(time.Second)
+	defer ts.Close()
+
+	cli := NewClient(BaseURI(ts.URL), Retries(1))
+	boom := errors.New("boom")
+	calls := []Call{
+		func(ctx context.Context) (*Response, error) { return cli.WithContext(ctx).Get("/") },
+		func(ctx context.Context) (*Response, error) { return nil, boom },
+		func(ctx context.Context) (*Response, error) { panic("should not start") },
+	}
+
+	start := time.Now()
+	results, err := FanOut(context.Background(), FanOutConfig{Concurrency: 2, FailFast: true}, calls...)
+	if err != boom {
+		t.Fatalf("expect the failed call error, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("other calls should be canceled")
+	}
+	if errorCode(results[0].Err) != CodeCanceled || errorCode(results[2].Err) != CodeCanceled {
+		t.Fatalf("expect canceled calls, got %v %v", results[0].Err, results[2].Err)
+	}
+}
+
+func TestFanOut_Timeout(t *testing.T) {
+	ts := newSlowServer(time.Second)
+	defer ts.Close()
+
+	cli := NewClient(BaseURI(ts.URL), Retries(1))
+	call := func(ctx context.Context) (*Response, error) { return cli.WithContext(ctx).Get("/") }
+	panicked := func(ctx context.Context) (*Response, error) { panic("boom") }
+
+	// 共享的截止时间对单次调用来说是调用方的 deadline
+	start := time.Now()
+	results, err := FanOut(context.Background(), FanOutConfig{Timeout: 50 * time.Millisecond}, call, panicked)
+	if errorCode(err) != CodeCanceled || errorCode(results[0].Err) != CodeCanceled || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("expect shared deadline, got %v", err)
+	}
+	if results[1].Err == nil {
+		t.Fatal("panic should be returned as error")
+	}
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	pathpkg "path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yulecd/pp-common/config"
+	"github.com/yulecd/pp-common/plog"
+)
+
+const (
+	FaultConfigName = "fault_injection"
+)
+
+// FaultConfig 故障注入配置 用于演练上游故障 prod 环境下需要 force 才能启用
+type FaultConfig struct {
+	Force bool        `json:"force" yaml:"force"` // prod 环境强制启用
+	Rules []FaultRule `json:"rules" yaml:"rules"`
+}
+
+// FaultRule 匹配的请求按比例注入故障 先等待 Latency 再按 Error Status Body 返回 都没配置时继续发送请求
+type FaultRule struct {
+	Service string        `json:"service" yaml:"service"` // 服务名 支持 * 通配 为空匹配所有
+	Path    string        `json:"path" yaml:"path"`       // 请求 path 支持 * 通配 为空匹配所有
+	Method  string        `json:"method" yaml:"method"`   // 为空匹配所有
+	Percent float64       `json:"percent" yaml:"percent"` // 注入比例 0-100
+	Latency time.Duration `json:"latency" yaml:"latency"` // 增加的延迟
+	Error   string        `json:"error" yaml:"error"`     // 返回连接错误 如 connection refused
+	Status  int           `json:"status" yaml:"status"`   // 不发送请求 直接返回的状态码 配置 Body 时默认 200
+	Body    string        `json:"body" yaml:"body"`       // 不发送请求 直接返回的响应体 如格式错误的json
+}
+
+func (r *FaultRule) match(service string, req *Request) bool {
+	hreq := req.GetRequest()
+	if r.Method != "" && !strings.EqualFold(r.Method, hreq.Method) {
+		return false
+	}
+	if r.Service != "" {
+		if ok, _ := pathpkg.Match(r.Service, service); !ok {
+			return false
+		}
+	}
+	if r.Path != "" {
+		if ok, _ := pathpkg.Match(r.Path, hreq.URL.Path); !ok {
+			return false
+		}
+	}
+	return rand.Float64()*100 < r.Percent
+}
+
+// FaultInjector 故障注入 规则可以热更新
+type FaultInjector struct {
+	mu    sync.RWMutex
+	rules []FaultRule
+}
+
+// NewFaultInjector instances a fault injector, rules are ignored in prod unless conf.Force is set.
+func NewFaultInjector(conf FaultConfig) *FaultInjector {
+	f := &FaultInjector{}
+	f.Update(conf)
+	return f
+}
+
+// Update replaces the rules, it is called when the config changes.
+func (f *FaultInjector) Update(conf FaultConfig) {
+	rules := conf.Rules
+	if len(rules) > 0 && os.Getenv(config.AppEnvName) == config.ProdEnv && !conf.Force {
+		plog.Errorf(nil, "client fault injection is not allowed in %s without force, %d rules ignored", config.ProdEnv, len(rules))
+		rules = nil
+	}
+
+	f.mu.Lock()
+	f.rules = rules
+	f.mu.Unlock()
+
+	if len(rules) > 0 {
+		plog.Warnf(nil, "client fault injection enabled, %d rules", len(rules))
+	}
+}
+
+// Wrapper is the WrapperChain of the injector, use it with Wrap(f.Wrapper).
+// Faults are injected outside the retry loop, retries configured on the client do not apply to them.
+func (f *FaultInjector) Wrapper(next Wrapper) Wrapper {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		rule := f.match(req)
+		if rule == nil {
+			return next(ctx, req)
+		}
+
+		hreq := req.GetRequest()
+		if req.Log() != nil {
+			req.Log().WithFields(logrus.Fields{
+				"service": req.opts.service,
+				"host":    hreq.URL.String(),
+				"latency": rule.Latency.String(),
+				"error":   rule.Error,
+				"status":  rule.Status,
+			}).Warnf("client fault injected: %s %s", hreq.Method, hreq.URL.Path)
+		}
+
+		if rule.Latency > 0 {
+			timer := time.NewTimer(rule.Latency)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		switch {
+		case rule.Error != "":
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New(rule.Error)}
+		case rule.Status > 0 || rule.Body != "":
+			status := rule.Status
+			if status == 0 {
+				status = 200
+			}
+			return syntheticResponse(req, status, nil, []byte(rule.Body)), nil
+		default:
+			return next(ctx, req)
+		}
+	}
+}
+
+func (f *FaultInjector) match(req *Request) *FaultRule {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for i := range f.rules {
+		if f.rules[i].match(req.opts.service, req) {
+			rule := f.rules[i]
+			return &rule
+		}
+	}
+	return nil
+}
+
+var (
+	faultInjector     *FaultInjector
+	faultInjectorOnce sync.Once
+)
+
+// getFaultInjector returns the injector loaded from FaultConfigName with hot reload,
+// nil if the config does not exist.
+func getFaultInjector() *FaultInjector {
+	faultInjectorOnce.Do(func() {
+		injector := &FaultInjector{}
+		var conf FaultConfig
+		err := config.LoadWithCallback(FaultConfigName, &conf, func(name string) {
+			var conf FaultConfig
+			if err := config.Load(name, &conf); err != nil {
+				plog.Errorf(nil, "client reload fault injection err: %v", err)
+				return
+			}
+			injector.Update(conf)
+		})
+		if err == nil {
+			injector.Update(conf)
+			faultInjector = injector
+		}
+	})
+	return faultInjector
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yulecd/pp-common/config"
+)
+
+func TestFaultInjector_Faults(t *testing.T) {
+	var hits int32
+	ts := newCountServer(http.StatusOK, &hits)
+	defer ts.Close()
+
+	injector := NewFaultInjector(FaultConfig{Rules: []FaultRule{
+		{Service: "pay*", Path: "/v1/refund", Percent: 100, Error: "connection refused"},
+		{Service: "pay*", Path: "/v1/*", Method: "GET", Percent: 100, Status: http.StatusServiceUnavailable},
+		{Path: "/v2/bad", Percent: 100, Body: `{"code":1,"data":`},
+		{Path: "/v2/slow", Percent: 100, Latency: 30 * time.Millisecond},
+	}})
+	cli := NewClient(BaseURI(ts.URL), ServiceName("payment"), Wrap(injector.Wrapper))
+
+	resp, err := cli.Get("/v1/order")
+	if err != nil || resp.GetStatusCode() != http.StatusServiceUnavailable {
+		t.Fatalf("expect injected 503, got %v %d", err, resp.GetStatusCode())
+	}
+	if _, err = cli.Get("/v1/refund"); err == nil {
+		t.Fatal("expect injected connection error")
+	} else if _, ok := err.(net.Error); !ok {
+		t.Fatalf("injected error should be a net error: %T", err)
+	}
+	resp, _ = cli.Get("/v2/bad")
+	var data map[string]interface{}
+	if _, err = resp.ParseBody(&data); err == nil {
+		t.Fatal("malformed body should fail to parse")
+	}
+	if n := atomic.LoadInt32(&hits); n != 0 {
+		t.Fatalf("injected faults should not reach upstream, hits %d", n)
+	}
+
+	start := time.Now()
+	resp, err = cli.Get("/v2/slow")
+	if err != nil || resp.GetStatusCode() != http.StatusOK || time.Since(start) < 30*time.Millisecond {
+		t.Fatalf("latency should be injected before sending: %v %v", err, time.Since(start))
+	}
+
+	// 其他服务不受影响
+	resp, err = NewClient(BaseURI(ts.URL), ServiceName("user"), Wrap(injector.Wrapper)).Get("/v1/order")
+	if err != nil || resp.GetStatusCode() != http.StatusOK {
+		t.Fatalf("other service should not be injected: %v %d", err, resp.GetStatusCode())
+	}
+}
+
+func TestFaultInjector_Prod(t *testing.T) {
+	t.Setenv(config.AppEnvName, config.ProdEnv)
+	rules := []FaultRule{{Percent: 100, Status: http.StatusBadGateway}}
+
+	if injector := NewFaultInjector(FaultConfig{Rules: rules}); injector.match(&Request{req: httptest.NewRequest("GET", "/", nil)}) != nil {
+		t.Fatal("fault injection should be disabled in prod")
+	}
+	if injector := NewFaultInjector(FaultConfig{Rules: rules, Force: true}); injector.match(&Request{req: httptest.NewRequest("GET", "/", nil)}) == nil {
+		t.Fatal("forced fault injection should be enabled in prod")
+	}
+}
+
+func TestFaultInjector_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	write := func(status int) {
+		content := fmt.Sprintf("fault_injection:\n  rules:\n    - path: /api\n      percent: 100\n      status: %d\n", status)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(http.StatusTooManyRequests)
+	if _, err := config.NewConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	faultInjector, faultInjectorOnce = nil, sync.Once{}
+
+	injector := getFaultInjector()
+	if injector == nil {
+		t.Fatal("injector should be loaded from config")
+	}
+	cli := NewClient(BaseURI("http://fault.invalid"), Wrap(injector.Wrapper))
+	if resp, _ := cli.Get("/api"); resp.GetStatusCode() != http.StatusTooManyRequests {
+		t.Fatalf("expect injected 429, got %d", resp.GetStatusCode())
+	}
+
+	write(http.StatusGatewayTimeout)
+	waitFor(t, func() bool {
+		resp, _ := cli.Get("/api")
+		return resp.GetStatusCode() == http.StatusGatewayTimeout
+	})
+}
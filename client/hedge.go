@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgeConfig 对冲请求配置 只对没有请求体的幂等请求生效
+// 第一次尝试超过延迟还没有返回时 向其他节点再发一次 使用先成功的响应 取消其他请求
+type HedgeConfig struct {
+	Delay       time.Duration `json:"delay" yaml:"delay"`               // 发出对冲请求前的等待时间 默认 100ms
+	Percentile  float64       `json:"percentile" yaml:"percentile"`     // 使用最近响应耗时的分位数作为延迟 如 95 样本不足时使用 Delay 0 不启用
+	MaxAttempts int           `json:"max_attempts" yaml:"max_attempts"` // 包括第一次在内最多同时发出的请求数 默认 2
+	Window      int           `json:"window" yaml:"window"`             // 计算分位数的样本数 默认 100
+}
+
+func (c *HedgeConfig) checkConf() {
+	if c.Delay <= 0 {
+		c.Delay = 100 * time.Millisecond
+	}
+	if c.Percentile < 0 || c.Percentile >= 100 {
+		c.Percentile = 0
+	}
+	if c.MaxAttempts < 2 {
+		c.MaxAttempts = 2
+	}
+	if c.Window <= 0 {
+		c.Window = 100
+	}
+}
+
+// minHedgeSamples 样本数少于它时分位数不可信 使用固定延迟
+const minHedgeSamples = 10
+
+// Hedger 对冲请求 对冲的请求从重试预算里扣除 预算不足时不发出
+type Hedger struct {
+	conf HedgeConfig
+
+	mu        sync.Mutex
+	latencies []time.Duration // 环形缓冲 最近成功请求的耗时
+	next      int
+}
+
+// NewHedger instances a hedger, zero fields of conf use defaults.
+func NewHedger(conf HedgeConfig) *Hedger {
+	conf.checkConf()
+	return &Hedger{
+		conf:      conf,
+		latencies: make([]time.Duration, 0, conf.Window),
+	}
+}
+
+// hedgeResult 一路请求的结果
+type hedgeResult struct {
+	req    *Request
+	resp   *Response
+	err    error
+	cancel context.CancelFunc
+	cost   time.Duration
+}
+
+// ok reports whether the upstream answered, 5xx is treated as failure.
+func (r *hedgeResult) ok() bool {
+	return r.err == nil && r.resp != nil && r.resp.hresp != nil && r.resp.hresp.StatusCode < http.StatusInternalServerError
+}
+
+// release discards the response and cancels its context.
+func (r *hedgeResult) release() {
+	r.resp.discard()
+	r.cancel()
+}
+
+// Wrapper is the WrapperChain of the hedger, use it with Wrap(h.Wrapper).
+// Every hedged attempt goes through the wrappers after it, so put it before breaker and signer.
+func (h *Hedger) Wrapper(next Wrapper) Wrapper {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		hreq := req.GetRequest()
+		if !isIdempotent(hreq) || (hreq.Body != nil && hreq.Body != http.NoBody) {
+			return next(ctx, req)
+		}
+
+		results := make(chan *hedgeResult, h.conf.MaxAttempts)
+		cancels := make(map[*Request]context.CancelFunc, h.conf.MaxAttempts)
+		launch := func(leg *Request) {
+			lctx, lcancel := context.WithCancel(ctx)
+			cancels[leg] = lcancel
+			start := time.Now()
+			go func() {
+				resp, err := next(lctx, leg)
+				results <- &hedgeResult{req: leg, resp: resp, err: err, cancel: lcancel, cost: time.Since(start)}
+			}()
+		}
+
+		// 每一路都使用副本 req 只在结束后更新 避免和仍在进行的请求竞争
+		launch(req.hedge(false))
+		sent, pending := 1, 1
+
+		timer := time.NewTimer(h.delay())
+		defer timer.Stop()
+
+		var last *hedgeResult
+		for pending > 0 {
+			select {
+			case res := <-results:
+				pending--
+				if res.ok() {
+					h.observe(res.cost)
+					for leg, lcancel := range cancels {
+						if leg != res.req {
+							lcancel()
+						}
+					}
+					go drainHedge(results, pending)
+					if last != nil {
+						last.release()
+					}
+					keepHedge(res)
+					req.adopt(res.req)
+					if res.req.hedged && req.Log() != nil {
+						req.Log().Infof("client hedged request won, host: %s", res.req.GetRequest().URL.Host)
+					}
+					return res.resp, nil
+				}
+				if last != nil {
+					last.release()
+				}
+				last = res
+			case <-timer.C:
+				if sent >= h.conf.MaxAttempts {
+					continue
+				}
+				if budget := req.opts.budget; budget != nil && !budget.withdraw() {
+					if req.Log() != nil {
+						req.Log().Warnf("client hedge skipped, retry budget exhausted: %s", hreq.URL.String())
+					}
+					continue
+				}
+				launch(req.hedge(true))
+				sent++
+				pending++
+				timer.Reset(h.delay())
+			}
+		}
+
+		// 都失败了 返回最后一个结果
+		keepHedge(last)
+		req.adopt(last.req)
+		return last.resp, last.err
+	}
+}
+
+// keepHedge keeps the context of the returned leg until its body is closed.
+func keepHedge(res *hedgeResult) {
+	if res.resp != nil && res.resp.hresp != nil && res.resp.hresp.Body != nil {
+		res.resp.hresp.Body = &cancelBody{ReadCloser: res.resp.hresp.Body, cancel: res.cancel}
+		return
+	}
+	res.cancel()
+}
+
+// drainHedge releases responses of legs which lost the race.
+func drainHedge(results chan *hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		(<-results).release()
+	}
+}
+
+// delay returns how long to wait before the next hedged attempt.
+func (h *Hedger) delay() time.Duration {
+	if h.conf.Percentile <= 0 {
+		return h.conf.Delay
+	}
+
+	h.mu.Lock()
+	if len(h.latencies) < minHedgeSamples {
+		h.mu.Unlock()
+		return h.conf.Delay
+	}
+	samples := append([]time.Duration(nil), h.latencies...)
+	h.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[int(float64(len(samples)-1)*h.conf.Percentile/100)]
+}
+
+// observe records latency of a successful attempt.
+func (h *Hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < h.conf.Window {
+		h.latencies = append(h.latencies, d)
+		return
+	}
+	h.latencies[h.next] = d
+	h.next = (h.next + 1) % h.conf.Window
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newHedgeServer sleeps delay before answering with name, canceled requests are counted.
+func newHedgeServer(name string, delay time.Duration, hits, canceled *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		select {
+		case <-time.After(delay):
+			w.Write([]byte(name))
+		case <-r.Context().Done():
+			atomic.AddInt32(canceled, 1)
+		}
+	}))
+}
+
+func TestHedger_OtherEndpoint(t *testing.T) {
+	var slowHits, fastHits, canceled int32
+	slow := newHedgeServer("slow", time.Second, &slowHits, &canceled)
+	defer slow.Close()
+	fast := newHedgeServer("fast", 0, &fastHits, &canceled)
+	defer fast.Close()
+
+	pool, _ := NewEndpointPool([]EndpointConfig{{URI: slow.URL}, {URI: fast.URL}}, LoadBalanceConfig{})
+	defer pool.Close()
+	hedger := NewHedger(HedgeConfig{Delay: 20 * time.Millisecond})
+	cli := NewClient(WithEndpointPool(pool), Wrap(hedger.Wrapper))
+
+	for i := 0; i < 4; i++ {
+		start := time.Now()
+		resp, err := cli.Get("/api")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := resp.GetBody(); body.String() != "fast" || time.Since(start) > 500*time.Millisecond {
+			t.Fatalf("fast endpoint should win: %q in %v", body, time.Since(start))
+		}
+	}
+
+	// 先发到慢节点的请求 对冲到快节点后被取消
+	slowN := atomic.LoadInt32(&slowHits)
+	if slowN == 0 || atomic.LoadInt32(&fastHits) != 4 {
+		t.Fatalf("unexpected hits slow %d fast %d", slowN, fastHits)
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&canceled) == slowN })
+}
+
+// closeBody counts closed response bodies.
+type closeBody struct {
+	io.ReadCloser
+	closed *int32
+}
+
+func (b *closeBody) Close() error {
+	atomic.AddInt32(b.closed, 1)
+	return b.ReadCloser.Close()
+}
+
+func TestHedger_ReleaseFailed(t *testing.T) {
+	var hits, closed int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 第一路在对冲发出后失败 对冲的一路随后成功
+		if atomic.AddInt32(&hits, 1) == 1 {
+			time.Sleep(40 * time.Millisecond)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(80 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	failed := func(next Wrapper) Wrapper {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			resp, err := next(ctx, req)
+			if err == nil && resp.hresp.StatusCode == http.StatusInternalServerError {
+				resp.hresp.Body = &closeBody{ReadCloser: resp.hresp.Body, closed: &closed}
+			}
+			return resp, err
+		}
+	}
+	hedger := NewHedger(HedgeConfig{Delay: 20 * time.Millisecond})
+	cli := NewClient(BaseURI(ts.URL), Wrap(hedger.Wrapper), Wrap(failed))
+
+	// 流式响应不会读完响应体 失败的一路需要关闭
+	resp, err := cli.Get("/api", Options{Stream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := resp.GetBody(); body.String() != "ok" {
+		t.Fatalf("hedged request should win: %q", body)
+	}
+	if n := atomic.LoadInt32(&closed); n != 1 {
+		t.Fatalf("failed response should be closed, closed %d", n)
+	}
+}
+
+func TestHedger_Skipped(t *testing.T) {
+	var hits, canceled int32
+	ts := newHedgeServer("ok", 50*time.Millisecond, &hits, &canceled)
+	defer ts.Close()
+
+	hedger := NewHedger(HedgeConfig{Delay: 5 * time.Millisecond, MaxAttempts: 3})
+	budget := NewRetryBudget(RetryBudgetConfig{Ratio: 0.01, MaxTokens: 1})
+	cli := NewClient(BaseURI(ts.URL), Wrap(hedger.Wrapper), WithRetryBudget(budget))
+
+	// 非幂等请求不对冲
+	if _, err := cli.Post("/api", Options{JSON: map[string]int{"a": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("post should not be hedged, hits %d", n)
+	}
+
+	// 预算只够一次对冲
+	atomic.StoreInt32(&hits, 0)
+	if _, err := cli.Get("/api"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("hedge should be limited by retry budget, hits %d", n)
+	}
+}
+
+func TestHedger_Percentile(t *testing.T) {
+	hedger := NewHedger(HedgeConfig{Delay: time.Second, Percentile: 90})
+	for i := 1; i <= 5; i++ {
+		hedger.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := hedger.delay(); d != time.Second {
+		t.Fatalf("delay should fall back without enough samples, got %v", d)
+	}
+
+	for i := 6; i <= 200; i++ {
+		hedger.observe(time.Duration(i) * time.Millisecond)
+	}
+	// 只保留最近100个样本 101ms ~ 200ms
+	if d := hedger.delay(); d != 190*time.Millisecond {
+		t.Fatalf("expect p90 190ms, got %v", d)
+	}
+}
@@ -1,16 +1,41 @@
 package client
 
-import "context"
+import (
+	"context"
+	"net/http"
+)
+
+// HttpClient 不可变的http客户端 创建后不再修改 可以在多个goroutine中共享
+type HttpClient struct {
+	opts Options
+	cli  *http.Client
+}
 
 // newHttpClient instances a http request
 func newHttpClient(opt ...Option) *Request {
 	opts := NewOptions(opt...)
 
-	req := &Request{
+	transport := opts.transport
+	if opts.record != nil {
+		transport = newRecorderTransport(opts.record.mode, opts.record.path, transport)
+	}
+
+	// client 创建一次 所有请求共享同一个transport连接池
+	// 超时由请求上下文控制 见 Timeout 和 AttemptTimeout
+	c := &HttpClient{
 		opts: opts,
+		cli: &http.Client{
+			Transport: transport,
+		},
 	}
 
-	req.WithContext(context.Background())
+	return c.WithContext(context.Background())
+}
 
-	return req
+// WithContext returns a request facade bound to ctx.
+func (c *HttpClient) WithContext(ctx context.Context) *Request {
+	return &Request{
+		ctx:    ctx,
+		client: c,
+	}
 }
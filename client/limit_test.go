@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_FailFast(t *testing.T) {
+	ts := newEchoServer()
+	defer ts.Close()
+
+	limiter := NewRateLimiter(RateLimitConfig{QPS: 1, Burst: 2, Mode: RateLimitFailFast})
+	cli := NewClient(Wrap(limiter.Wrapper))
+
+	for i := 0; i < 2; i++ {
+		resp, err := cli.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		resp.GetBody()
+	}
+
+	if _, err := cli.Get(ts.URL); errorCode(err) != CodeRateLimited {
+		t.Fatalf("expect rate limited, got %v", err)
+	}
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{QPS: 20, Burst: 1})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cost := time.Since(start); cost < 90*time.Millisecond {
+		t.Fatalf("wait mode should be paced by qps, cost %s", cost)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	limiter.wait(context.Background())
+	if err := limiter.wait(ctx); errorCode(err) != CodeRateLimited {
+		t.Fatalf("wait beyond deadline should fail fast, got %v", err)
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+
+	bulkhead := NewBulkhead(BulkheadConfig{MaxConcurrent: 1})
+	cli := NewClient(Wrap(bulkhead.Wrapper))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := cli.Get(ts.URL); err != nil {
+			t.Errorf("first request: %v", err)
+		}
+	}()
+
+	for bulkhead.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := cli.Get(ts.URL); errorCode(err) != CodeBulkheadFull {
+		t.Fatalf("expect bulkhead full, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	if bulkhead.InFlight() != 0 {
+		t.Fatal("bulkhead should be released")
+	}
+}
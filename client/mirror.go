@@ -0,0 +1,370 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	urlpkg "net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/yulecd/pp-common/plog"
+	"github.com/yulecd/pp-common/trace"
+)
+
+// MirrorConfig 流量镜像配置 按比例把请求异步复制到影子节点 丢弃影子响应 只记录和主请求的差异
+// 影子请求有独立的连接池和并发上限 并发已满时直接丢弃 不会拖慢主请求
+type MirrorConfig struct {
+	BaseURI     string           `json:"base_uri" yaml:"base_uri"`           // 影子节点 替换主请求的 scheme 和 host 保留 path 和 query
+	Percent     float64          `json:"percent" yaml:"percent"`             // 镜像比例 0-100
+	Methods     []string         `json:"methods" yaml:"methods"`             // 镜像的请求方法 默认 GET HEAD 非幂等的方法需要明确配置
+	Trusted     bool             `json:"trusted" yaml:"trusted"`             // 影子节点可信 为true时保留 Authorization Cookie 等凭证header
+	Timeout     time.Duration    `json:"timeout" yaml:"timeout"`             // 影子请求超时时间 默认 1s
+	MaxInflight int              `json:"max_inflight" yaml:"max_inflight"`   // 同时进行的影子请求数 默认 10
+	MaxBodySize int64            `json:"max_body_size" yaml:"max_body_size"` // 请求体超过时不镜像 也是比较响应体的最大长度 默认 64KB
+	Transport   *TransportConfig `json:"transport" yaml:"transport"`         // 影子请求的连接池 不配置使用默认配置
+}
+
+func (c *MirrorConfig) checkConf() {
+	if len(c.Methods) == 0 {
+		c.Methods = DefaultMirrorMethods
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.MaxInflight <= 0 {
+		c.MaxInflight = 10
+	}
+	if c.MaxBodySize <= 0 {
+		c.MaxBodySize = 64 << 10
+	}
+}
+
+func (c *MirrorConfig) validate() error {
+	u, err := urlpkg.Parse(c.BaseURI)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("mirror: invalid base_uri %q", c.BaseURI)
+	}
+	return nil
+}
+
+// DefaultMirrorMethods 默认镜像的请求方法 只读请求重复发送没有副作用
+var DefaultMirrorMethods = []string{http.MethodGet, http.MethodHead}
+
+// MirrorCredentialHeaders 影子节点不可信时去掉的凭证header
+var MirrorCredentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// maxMirrorDiffs 日志里最多记录的响应体差异数
+const maxMirrorDiffs = 10
+
+// Mirror 流量镜像
+type Mirror struct {
+	conf MirrorConfig
+	base *urlpkg.URL
+	cli  *http.Client
+	sem  chan struct{}
+}
+
+// NewMirror instances a mirror, zero fields of conf use defaults.
+func NewMirror(conf MirrorConfig) (*Mirror, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	conf.checkConf()
+
+	tc := TransportConfig{MaxConnsPerHost: conf.MaxInflight}
+	if conf.Transport != nil {
+		tc = *conf.Transport
+	}
+	transport := NewTransport(tc)
+	base, _ := urlpkg.Parse(conf.BaseURI)
+
+	return &Mirror{
+		conf: conf,
+		base: base,
+		cli:  &http.Client{Transport: transport},
+		sem:  make(chan struct{}, conf.MaxInflight),
+	}, nil
+}
+
+// Close releases connections of shadow requests, it is called when the config changes.
+func (m *Mirror) Close() error {
+	releaseShared(m.cli.Transport)
+	return nil
+}
+
+// Wrapper is the WrapperChain of the mirror, use it with Wrap(m.Wrapper).
+// The shadow request is copied from the last attempt after the primary one returns,
+// so headers set by the wrappers after it such as signature are kept. Its response is discarded.
+func (m *Mirror) Wrapper(next Wrapper) Wrapper {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		if !m.sample(req) {
+			return next(ctx, req)
+		}
+
+		start := time.Now()
+		resp, err := next(ctx, req)
+
+		// 并发已满直接丢弃 不等待
+		select {
+		case m.sem <- struct{}{}:
+		default:
+			return resp, err
+		}
+		shadow := m.shadowRequest(req)
+		if shadow == nil {
+			<-m.sem
+			return resp, err
+		}
+
+		primary := &mirrorPrimary{cost: time.Since(start), err: err}
+		if resp != nil && resp.hresp != nil {
+			primary.status = resp.hresp.StatusCode
+			switch {
+			case resp.copyBody != nil:
+				primary.body = &mirrorBody{buf: resp.copyBody, done: closedChan}
+			case resp.hresp.Body != nil:
+				body := &mirrorBody{ReadCloser: resp.hresp.Body, limit: m.conf.MaxBodySize, done: make(chan struct{})}
+				resp.hresp.Body = body
+				primary.body = body
+			}
+		}
+
+		go m.send(shadow, primary, req.Log())
+		return resp, err
+	}
+}
+
+// sample decides whether the request is mirrored, streamed or large request bodies are never mirrored.
+func (m *Mirror) sample(req *Request) bool {
+	hreq := req.GetRequest()
+	if !m.match(hreq.Method) || rand.Float64()*100 >= m.conf.Percent {
+		return false
+	}
+	return req.stream == nil && hreq.ContentLength <= m.conf.MaxBodySize &&
+		(hreq.Body == nil || hreq.Body == http.NoBody || hreq.GetBody != nil)
+}
+
+// shadowRequest returns the copy of the request to send to the shadow endpoint, nil if the body can not be copied.
+// Credential headers are removed unless the shadow is trusted.
+func (m *Mirror) shadowRequest(req *Request) *http.Request {
+	hreq := req.GetRequest()
+	shadow := hreq.Clone(context.Background())
+	u := *hreq.URL
+	u.Scheme = m.base.Scheme
+	u.Host = m.base.Host
+	u.Path = strings.TrimSuffix(m.base.Path, "/") + u.Path
+	u.RawPath = ""
+	shadow.URL = &u
+	shadow.Host = u.Host
+	if !m.conf.Trusted {
+		for _, k := range MirrorCredentialHeaders {
+			shadow.Header.Del(k)
+		}
+	}
+	shadow.Body = nil
+	if hreq.GetBody != nil {
+		body, err := hreq.GetBody()
+		if err != nil {
+			return nil
+		}
+		shadow.Body = body
+	}
+	if entry := req.Log(); entry != nil {
+		if traceID, ok := entry.Data["trace"].(string); ok {
+			shadow.Header.Set(trace.HeaderTraceIdKey, traceID)
+		}
+	}
+	return shadow
+}
+
+func (m *Mirror) match(method string) bool {
+	for _, mm := range m.conf.Methods {
+		if strings.EqualFold(mm, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// send sends the shadow request and logs the difference with the primary response.
+func (m *Mirror) send(shadow *http.Request, primary *mirrorPrimary, log *plog.Entry) {
+	defer func() { <-m.sem }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.conf.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	var (
+		status int
+		body   []byte
+	)
+	hresp, err := m.cli.Do(shadow.WithContext(ctx))
+	if err == nil {
+		status = hresp.StatusCode
+		body, err = ioutil.ReadAll(io.LimitReader(hresp.Body, m.conf.MaxBodySize))
+		hresp.Body.Close()
+	}
+	cost := time.Since(start)
+
+	fields := logrus.Fields{
+		"host":           shadow.URL.String(),
+		"primary_status": primary.status,
+		"shadow_status":  status,
+		"primary_cost":   float64(primary.cost.Microseconds()) / 1000,
+		"shadow_cost":    float64(cost.Microseconds()) / 1000,
+	}
+	if primary.err != nil {
+		fields["primary_err"] = primary.err.Error()
+	}
+	if err != nil {
+		fields["shadow_err"] = err.Error()
+	}
+
+	match := primary.err == nil && err == nil && primary.status == status
+	if primary.body != nil && err == nil {
+		// 等待主请求读完响应体 超时后不比较
+		select {
+		case <-primary.body.done:
+			diffs := diffBody(primary.body.bytes(), body)
+			fields["body_diff"] = strings.Join(diffs, ",")
+			match = match && len(diffs) == 0
+		case <-ctx.Done():
+			fields["body_diff"] = "primary body not read"
+		}
+	}
+
+	if log == nil {
+		return
+	}
+	if match {
+		log.WithFields(fields).Infof("client mirror match: %s %s", shadow.Method, shadow.URL.Path)
+	} else {
+		log.WithFields(fields).Warnf("client mirror diff: %s %s", shadow.Method, shadow.URL.Path)
+	}
+}
+
+// mirrorPrimary 主请求的结果
+type mirrorPrimary struct {
+	status int
+	cost   time.Duration
+	err    error
+	body   *mirrorBody
+}
+
+// closedChan 已经读完的响应体使用
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// mirrorBody 复制主请求读取的响应体 最多 limit 字节 关闭后才能读取复制的内容
+type mirrorBody struct {
+	io.ReadCloser
+	limit     int64
+	buf       []byte
+	truncated bool
+	done      chan struct{}
+	once      sync.Once
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.truncated {
+		if left := b.limit - int64(len(b.buf)); int64(n) <= left {
+			b.buf = append(b.buf, p[:n]...)
+		} else {
+			b.buf = append(b.buf, p[:left]...)
+			b.truncated = true
+		}
+	}
+	return n, err
+}
+
+func (b *mirrorBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		close(b.done)
+	})
+	return err
+}
+
+// bytes returns the copied body, it must be called after done is closed.
+func (b *mirrorBody) bytes() []byte {
+	return b.buf
+}
+
+// diffBody returns the json paths which differ, json bodies are compared by value, others byte by byte.
+func diffBody(primary, shadow []byte) []string {
+	var a, b interface{}
+	if jsoniter.Unmarshal(primary, &a) != nil || jsoniter.Unmarshal(shadow, &b) != nil {
+		if bytes.Equal(primary, shadow) {
+			return nil
+		}
+		return []string{"$"}
+	}
+
+	var diffs []string
+	diffJSON("$", a, b, &diffs)
+	return diffs
+}
+
+func diffJSON(path string, a, b interface{}, diffs *[]string) {
+	if len(*diffs) >= maxMirrorDiffs {
+		return
+	}
+
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if aok && bok {
+		keys := make([]string, 0, len(am)+len(bm))
+		for k := range am {
+			keys = append(keys, k)
+		}
+		for k := range bm {
+			if _, ok := am[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			diffJSON(path+"."+k, am[k], bm[k], diffs)
+		}
+		return
+	}
+
+	as, aok := a.([]interface{})
+	bs, bok := b.([]interface{})
+	if aok && bok && len(as) == len(bs) {
+		for i := range as {
+			diffJSON(fmt.Sprintf("%s[%d]", path, i), as[i], bs[i], diffs)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*diffs = append(*diffs, path)
+	}
+}
+
+// getServiceMirror returns the shared mirror of service.
+func getServiceMirror(service string, conf MirrorConfig) (*Mirror, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	return getShared("mirror", service, conf, func() interface{} {
+		m, _ := NewMirror(conf)
+		return m
+	}).(*Mirror), nil
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yulecd/pp-common/trace"
+)
+
+func TestMirror_Shadow(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":1,"data":{"id":7}}`))
+	}))
+	defer primary.Close()
+
+	shadowed := make(chan *http.Request, 10)
+	bodies := make(chan string, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		shadowed <- r
+		bodies <- string(body)
+		w.Write([]byte(`{"code":1,"data":{"id":8}}`))
+	}))
+	defer shadow.Close()
+
+	m, err := NewMirror(MirrorConfig{BaseURI: shadow.URL + "/shadow", Percent: 100, Methods: []string{"POST"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := NewClient(BaseURI(primary.URL), Wrap(m.Wrapper))
+
+	resp, err := cli.Post("/v1/order", Options{
+		JSON:    map[string]int{"id": 7},
+		Query:   "a=1",
+		Headers: map[string]interface{}{"Authorization": "Bearer primary", "Cookie": "session=1", "x-app": "order"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := resp.GetBody(); !strings.Contains(string(body), `"id":7`) {
+		t.Fatalf("primary response should be returned, got %s", body)
+	}
+
+	select {
+	case r := <-shadowed:
+		if r.Method != "POST" || r.URL.Path != "/shadow/v1/order" || r.URL.RawQuery != "a=1" || r.Header.Get(trace.HeaderTraceIdKey) == "" {
+			t.Fatalf("unexpected shadow request %s %s %v", r.Method, r.URL, r.Header)
+		}
+		// 影子节点不可信时不带凭证
+		if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" || r.Header.Get("x-app") != "order" {
+			t.Fatalf("credential headers should be removed: %v", r.Header)
+		}
+		if body := <-bodies; body != `{"id":7}` {
+			t.Fatalf("request body should be mirrored, got %s", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request should be mirrored")
+	}
+
+	// 没有配置的方法不镜像
+	cli.Get("/v1/order")
+	select {
+	case r := <-shadowed:
+		t.Fatalf("GET should not be mirrored: %s", r.URL)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMirror_Limit(t *testing.T) {
+	primary := newTagServer("primary")
+	defer primary.Close()
+
+	var hits int32
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	m, _ := NewMirror(MirrorConfig{BaseURI: shadow.URL, Percent: 100, MaxInflight: 1, Timeout: 5 * time.Second})
+	cli := NewClient(BaseURI(primary.URL), Wrap(m.Wrapper))
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := cli.Get("/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("slow shadow should not slow down primary requests")
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&hits) == 1 })
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("shadow requests over the limit should be dropped, got %d", n)
+	}
+}
+
+func TestMirror_DiffBody(t *testing.T) {
+	cases := []struct {
+		primary, shadow string
+		expect          string
+	}{
+		{`{"a":1,"b":[1,2]}`, `{"b":[1,2],"a":1}`, ""},
+		{`{"a":1,"b":[1,2],"c":"x"}`, `{"a":2,"b":[1,3]}`, "$.a,$.b[1],$.c"},
+		{`{"a":[1]}`, `{"a":[1,2]}`, "$.a"},
+		{`plain`, `plain`, ""},
+		{`plain`, `other`, "$"},
+	}
+	for _, c := range cases {
+		if diff := strings.Join(diffBody([]byte(c.primary), []byte(c.shadow)), ","); diff != c.expect {
+			t.Fatalf("diff %s %s: expect %q, got %q", c.primary, c.shadow, c.expect, diff)
+		}
+	}
+}
+
+func TestMirror_Methods(t *testing.T) {
+	m, err := NewMirror(MirrorConfig{BaseURI: "http://shadow.internal", Percent: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for method, expect := range map[string]bool{"GET": true, "HEAD": true, "POST": false, "DELETE": false} {
+		if m.match(method) != expect {
+			t.Fatalf("default methods: %s expect %v", method, expect)
+		}
+	}
+}
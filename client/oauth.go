@@ -0,0 +1,308 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	urlpkg "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yulecd/pp-common/plog"
+	"github.com/yulecd/pp-common/redis"
+)
+
+// OAuth2Config client credentials 模式获取token
+type OAuth2Config struct {
+	TokenURL      string        `json:"token_url" yaml:"token_url"`
+	ClientID      string        `json:"client_id" yaml:"client_id"`
+	ClientSecret  string        `json:"client_secret" yaml:"client_secret"`
+	Scopes        []string      `json:"scopes" yaml:"scopes"`
+	AuthStyle     string        `json:"auth_style" yaml:"auth_style"`         // header 使用 basic auth, params 放在表单里 默认 header
+	RefreshBefore time.Duration `json:"refresh_before" yaml:"refresh_before"` // 过期前多久后台刷新 最多为有效期的一半 默认 60s
+	Timeout       time.Duration `json:"timeout" yaml:"timeout"`               // 获取token超时时间 默认 5s
+	Redis         string        `json:"redis" yaml:"redis"`                   // redis 服务名 配置后多个实例共享token
+	RedisKey      string        `json:"redis_key" yaml:"redis_key"`           // 默认 client:oauth2:<client_id>
+}
+
+func (c *OAuth2Config) validate() error {
+	if c.TokenURL == "" || c.ClientID == "" {
+		return fmt.Errorf("oauth2: token_url and client_id are required")
+	}
+	return nil
+}
+
+func (c *OAuth2Config) checkConf() {
+	if c.AuthStyle != "params" {
+		c.AuthStyle = "header"
+	}
+	if c.RefreshBefore <= 0 {
+		c.RefreshBefore = time.Minute
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.RedisKey == "" {
+		c.RedisKey = "client:oauth2:" + c.ClientID
+	}
+}
+
+// Token oauth2 access token
+type Token struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int64     `json:"expires_in"` // 有效期 秒
+	Expiry      time.Time `json:"expiry"`     // 过期时间 根据 expires_in 计算
+}
+
+// Authorization returns value of the Authorization header.
+func (t *Token) Authorization() string {
+	typ := t.TokenType
+	if typ == "" || strings.EqualFold(typ, "bearer") {
+		typ = "Bearer"
+	}
+	return typ + " " + t.AccessToken
+}
+
+// valid reports whether t can still be used at now.
+func (t *Token) valid(now time.Time) bool {
+	return t != nil && t.AccessToken != "" && now.Before(t.Expiry)
+}
+
+// TokenSource 获取并缓存token 快过期时后台刷新 同一时间只有一个刷新请求
+type TokenSource struct {
+	conf OAuth2Config
+	cli  *Request
+
+	mu      sync.Mutex
+	token   *Token
+	revoked string // 被服务端拒绝的token redis里的同一个token也不再使用
+	pending *tokenCall
+}
+
+// tokenCall 正在进行的刷新 等待的调用方共享结果
+type tokenCall struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// NewTokenSource instances a client credentials token source.
+func NewTokenSource(conf OAuth2Config) (*TokenSource, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	conf.checkConf()
+
+	return &TokenSource{
+		conf: conf,
+		// 请求里有 client secret 响应里有 token 都不能打印到日志
+		cli: NewClient(Timeout(conf.Timeout), Sensitive()),
+	}, nil
+}
+
+// Token returns a valid token, it is fetched when there is no valid one in cache.
+func (s *TokenSource) Token(ctx context.Context) (*Token, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	token := s.token
+	if token.valid(now) {
+		if now.After(s.refreshAt(token)) && s.pending == nil {
+			// 快过期了 后台刷新 当前请求继续使用旧token
+			s.startRefresh()
+		}
+		s.mu.Unlock()
+		return token, nil
+	}
+
+	call := s.pending
+	if call == nil {
+		call = s.startRefresh()
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Invalidate marks token as rejected by the server, the next call fetches a new one if it is still cached.
+func (s *TokenSource) Invalidate(token *Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token == nil {
+		return
+	}
+	s.revoked = token.AccessToken
+	if s.token != nil && s.token.AccessToken == token.AccessToken {
+		s.token = nil
+	}
+}
+
+// Wrapper sets the Authorization header, the request is sent again with a new token on 401.
+func (s *TokenSource) Wrapper(next Wrapper) Wrapper {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		token, err := s.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.GetRequest().Header.Set("Authorization", token.Authorization())
+
+		resp, err := next(ctx, req)
+		if err != nil || resp.GetStatusCode() != http.StatusUnauthorized || !req.rewindable() {
+			return resp, err
+		}
+
+		// token 可能已经被服务端吊销 换新token重试一次
+		s.Invalidate(token)
+		fresh, terr := s.Token(ctx)
+		if terr != nil || fresh.AccessToken == token.AccessToken {
+			return resp, err
+		}
+		resp.discard()
+		req.GetRequest().Header.Set("Authorization", fresh.Authorization())
+
+		return next(ctx, req)
+	}
+}
+
+// refreshAt returns when token should be refreshed in background.
+func (s *TokenSource) refreshAt(token *Token) time.Time {
+	before := s.conf.RefreshBefore
+	if half := time.Duration(token.ExpiresIn) * time.Second / 2; half > 0 && before > half {
+		before = half
+	}
+	return token.Expiry.Add(-before)
+}
+
+// startRefresh starts a refresh, caller must hold the lock.
+func (s *TokenSource) startRefresh() *tokenCall {
+	call := &tokenCall{done: make(chan struct{})}
+	s.pending = call
+
+	go func() {
+		// 不受单个请求上下文的影响 等待的其他请求也要使用结果
+		ctx, cancel := context.WithTimeout(context.Background(), s.conf.Timeout)
+		defer cancel()
+
+		token, err := s.fetch(ctx)
+
+		s.mu.Lock()
+		if err == nil {
+			s.token = token
+		}
+		s.pending = nil
+		s.mu.Unlock()
+
+		if err != nil {
+			plog.Warnf(nil, "client oauth2 fetch token err: %v", err)
+		}
+		call.token, call.err = token, err
+		close(call.done)
+	}()
+
+	return call
+}
+
+// fetch loads token shared by other instances or requests a new one from the token endpoint.
+func (s *TokenSource) fetch(ctx context.Context) (*Token, error) {
+	store := s.store()
+	if store != nil {
+		s.mu.Lock()
+		revoked := s.revoked
+		s.mu.Unlock()
+
+		var token Token
+		content, err := store.Get(ctx, s.conf.RedisKey).Bytes()
+		if err == nil && json.Unmarshal(content, &token) == nil && token.AccessToken != revoked &&
+			token.valid(time.Now()) && time.Now().Before(s.refreshAt(&token)) {
+			return &token, nil
+		}
+	}
+
+	token, err := s.request(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if store != nil {
+		content, _ := json.Marshal(token)
+		if err = store.Set(ctx, s.conf.RedisKey, content, time.Until(token.Expiry)).Err(); err != nil {
+			plog.Warnf(nil, "client oauth2 save token to redis err: %v", err)
+		}
+	}
+
+	return token, nil
+}
+
+func (s *TokenSource) request(ctx context.Context) (*Token, error) {
+	form := map[string]interface{}{"grant_type": "client_credentials"}
+	if len(s.conf.Scopes) > 0 {
+		form["scope"] = strings.Join(s.conf.Scopes, " ")
+	}
+	opts := Options{FormParams: form}
+	if s.conf.AuthStyle == "params" {
+		form["client_id"] = s.conf.ClientID
+		form["client_secret"] = s.conf.ClientSecret
+	} else {
+		credentials := urlpkg.QueryEscape(s.conf.ClientID) + ":" + urlpkg.QueryEscape(s.conf.ClientSecret)
+		opts.Headers = map[string]interface{}{
+			"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)),
+		}
+	}
+
+	start := time.Now()
+	resp, err := s.cli.WithContext(ctx).Post(s.conf.TokenURL, opts)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: request token err: %w", err)
+	}
+	body, err := resp.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: read token err: %w", err)
+	}
+	if resp.GetStatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("oauth2: token endpoint status %d: %s", resp.GetStatusCode(), body)
+	}
+
+	token := &Token{}
+	if err = json.Unmarshal(body, token); err != nil {
+		return nil, fmt.Errorf("oauth2: parse token err: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("oauth2: empty access token: %s", body)
+	}
+	if token.ExpiresIn <= 0 {
+		// 没有返回有效期 按一小时处理
+		token.ExpiresIn = 3600
+	}
+	token.Expiry = start.Add(time.Duration(token.ExpiresIn) * time.Second)
+
+	return token, nil
+}
+
+func (s *TokenSource) store() *redis.Client {
+	if s.conf.Redis == "" {
+		return nil
+	}
+	return redis.GetClient(s.conf.Redis)
+}
+
+// getServiceTokenSource returns the shared token source of service.
+func getServiceTokenSource(service string, conf OAuth2Config) (*TokenSource, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	return getShared("oauth2", service, conf, func() interface{} {
+		source, _ := NewTokenSource(conf)
+		return source
+	}).(*TokenSource), nil
+}
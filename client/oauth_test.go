@@ -0,0 +1,146 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yulecd/pp-common/plog"
+)
+
+// newTokenServer issues token-1, token-2 ... and only accepts the latest one on /api.
+func newTokenServer(expiresIn int) (*httptest.Server, *int32) {
+	var issued int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			id, secret, ok := r.BasicAuth()
+			if !ok || id != "cid" || secret != "csecret" || r.FormValue("grant_type") != "client_credentials" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+			n := atomic.AddInt32(&issued, 1)
+			fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d}`, n, expiresIn)
+		default:
+			if r.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%d", atomic.LoadInt32(&issued)) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("ok"))
+		}
+	})), &issued
+}
+
+func TestTokenSource_SingleFlight(t *testing.T) {
+	ts, issued := newTokenServer(3600)
+	defer ts.Close()
+
+	source, err := NewTokenSource(OAuth2Config{TokenURL: ts.URL + "/token", ClientID: "cid", ClientSecret: "csecret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := NewClient(BaseURI(ts.URL), Wrap(source.Wrapper))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := cli.Get("/api")
+			if err != nil || resp.GetStatusCode() != http.StatusOK {
+				t.Errorf("request failed: %v %d", err, resp.GetStatusCode())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(issued); n != 1 {
+		t.Fatalf("token should be fetched once, got %d", n)
+	}
+}
+
+func TestTokenSource_Unauthorized(t *testing.T) {
+	ts, issued := newTokenServer(3600)
+	defer ts.Close()
+
+	source, _ := NewTokenSource(OAuth2Config{TokenURL: ts.URL + "/token", ClientID: "cid", ClientSecret: "csecret"})
+	cli := NewClient(BaseURI(ts.URL), Wrap(source.Wrapper))
+	if _, err := cli.Get("/api"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务端换了token 旧token返回401后换新token重试
+	atomic.AddInt32(issued, 1)
+	resp, err := cli.Post("/api", Options{JSON: map[string]int{"a": 1}})
+	if err != nil || resp.GetStatusCode() != http.StatusOK {
+		t.Fatalf("request should be retried with new token: %v %d", err, resp.GetStatusCode())
+	}
+	if n := atomic.LoadInt32(issued); n != 3 {
+		t.Fatalf("expect a new token after 401, issued %d", n)
+	}
+}
+
+func TestTokenSource_Refresh(t *testing.T) {
+	ts, issued := newTokenServer(2)
+	defer ts.Close()
+
+	source, _ := NewTokenSource(OAuth2Config{
+		TokenURL:      ts.URL + "/token",
+		ClientID:      "cid",
+		ClientSecret:  "csecret",
+		RefreshBefore: time.Minute,
+	})
+
+	first, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 有效期过半后 返回旧token并后台刷新
+	time.Sleep(1100 * time.Millisecond)
+	token, err := source.Token(context.Background())
+	if err != nil || token.AccessToken != first.AccessToken {
+		t.Fatalf("old token should be used while refreshing: %v %v", token, err)
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(issued) == 2 })
+	waitFor(t, func() bool {
+		token, _ := source.Token(context.Background())
+		return token.AccessToken == "token-2"
+	})
+}
+
+func TestTokenSource_Log(t *testing.T) {
+	ts, _ := newTokenServer(3600)
+	defer ts.Close()
+
+	var out bytes.Buffer
+	logger := plog.GetDefaultFieldEntry(context.Background()).Logger
+	logger.SetOutput(&out)
+	defer logger.SetOutput(os.Stderr)
+
+	for _, style := range []string{"header", "params"} {
+		source, _ := NewTokenSource(OAuth2Config{TokenURL: ts.URL + "/token", ClientID: "cid", ClientSecret: "csecret", AuthStyle: style})
+		source.Token(context.Background())
+	}
+
+	// client secret 和 token 都不能出现在日志里
+	credentials := base64.StdEncoding.EncodeToString([]byte("cid:csecret"))
+	if !strings.Contains(out.String(), "/token") {
+		t.Fatalf("token request should be logged:\n%s", out.String())
+	}
+	for _, secret := range []string{"csecret", credentials, "token-1"} {
+		if strings.Contains(out.String(), secret) {
+			t.Fatalf("%s should not be logged:\n%s", secret, out.String())
+		}
+	}
+}
@@ -1,32 +1,52 @@
 package client
 
 import (
+	"io"
+	"net/http"
 	"time"
 )
 
 type Options struct {
-	debug      bool
-	timeout    time.Duration
-	backoff    BackoffFunc
-	retry      RetryFunc
-	retries    int
-	wrappers   []WrapperChain
-	BaseURI    string
-	Query      interface{}
-	Headers    map[string]interface{}
-	FormParams map[string]interface{}
-	JSON       interface{}
+	debug          bool
+	timeout        time.Duration
+	attemptTimeout time.Duration
+	backoff        BackoffFunc
+	retry          RetryFunc
+	retries        int
+	maxRetryAfter  time.Duration
+	budget         *RetryBudget
+	wrappers       []WrapperChain
+	transport      http.RoundTripper
+	pool           *EndpointPool
+	record         *recordOption
+	service        string
+	envelope       *EnvelopeConfig
+	router         *Router
+	compress       *CompressConfig
+	maxRespSize    int64
+	sensitive      bool
+	BaseURI        string
+	Query          interface{}
+	Headers        map[string]interface{}
+	FormParams     map[string]interface{}
+	JSON           interface{}
+	Body           io.Reader // 原始请求体 只用于单次调用 实现 io.Seeker 时可以安全重试
+	ContentType    string    // Body 的 Content-Type 默认 application/octet-stream
+	Multipart      []Part    // multipart/form-data 上传 FormParams 作为普通字段一起发送
+	Stream         bool      // 不预先读取响应体 调用方需要读取并关闭 见 Response.BodyReader
 }
 
 // NewOptions instances default Options
 func NewOptions(options ...Option) Options {
 	opts := Options{
-		debug:    false,
-		timeout:  DefaultTimeout,
-		backoff:  DefaultBackoff,
-		retry:    DefaultRetry,
-		retries:  DefaultRetries,
-		wrappers: DefaultWrappers,
+		debug:         false,
+		timeout:       DefaultTimeout,
+		backoff:       DefaultBackoff,
+		retry:         DefaultRetry,
+		retries:       DefaultRetries,
+		maxRetryAfter: DefaultMaxRetryAfter,
+		wrappers:      append([]WrapperChain(nil), DefaultWrappers...),
+		transport:     DefaultTransport,
 	}
 
 	for _, o := range options {
@@ -36,9 +56,27 @@ func NewOptions(options ...Option) Options {
 	return opts
 }
 
+// clone returns a copy of o which can be modified without affecting o.
+func (o Options) clone() Options {
+	if o.Headers != nil {
+		headers := make(map[string]interface{}, len(o.Headers))
+		for k, v := range o.Headers {
+			headers[k] = v
+		}
+		o.Headers = headers
+	}
+	o.wrappers = o.wrappers[:len(o.wrappers):len(o.wrappers)]
+
+	return o
+}
+
+// merge merges per call options into o, per call headers override the default ones.
+// o must be a clone, opts is never modified.
 func (o *Options) merge(opts Options) {
 	if opts.BaseURI != "" {
+		// 单次指定了BaseURI 不再使用节点池
 		o.BaseURI = opts.BaseURI
+		o.pool = nil
 	}
 	if opts.Query != nil {
 		o.Query = opts.Query
@@ -49,8 +87,25 @@ func (o *Options) merge(opts Options) {
 	if opts.JSON != nil {
 		o.JSON = opts.JSON
 	}
+	if opts.Body != nil {
+		o.Body = opts.Body
+	}
+	if opts.ContentType != "" {
+		o.ContentType = opts.ContentType
+	}
+	if opts.Multipart != nil {
+		o.Multipart = opts.Multipart
+	}
+	if opts.Stream {
+		o.Stream = true
+	}
 	if opts.Headers != nil {
-		o.Headers = opts.Headers
+		if o.Headers == nil {
+			o.Headers = make(map[string]interface{}, len(opts.Headers))
+		}
+		for k, v := range opts.Headers {
+			o.Headers[k] = v
+		}
 	}
 }
 
@@ -75,13 +130,20 @@ func Wrap(w WrapperChain) Option {
 	}
 }
 
-// The request timeout.
+// The request timeout, it covers all retries and reading the response body.
 func Timeout(t time.Duration) Option {
 	return func(o *Options) {
 		o.timeout = t
 	}
 }
 
+// AttemptTimeout sets timeout of a single attempt, 0 means only the overall timeout applies.
+func AttemptTimeout(t time.Duration) Option {
+	return func(o *Options) {
+		o.attemptTimeout = t
+	}
+}
+
 // Retry sets the retry function to be used when re-trying.
 func Retry(fn RetryFunc) Option {
 	return func(o *Options) {
@@ -89,9 +151,112 @@ func Retry(fn RetryFunc) Option {
 	}
 }
 
+// WithRetryBudget limits retries of the client by budget, the budget can be shared among clients.
+func WithRetryBudget(b *RetryBudget) Option {
+	return func(o *Options) {
+		o.budget = b
+	}
+}
+
+type recordOption struct {
+	mode string
+	path string
+}
+
+// Record records requests to or replays them from the HAR cassette at path, see Recorder.
+// It wraps the transport of the client, so the order with Transport option does not matter.
+func Record(mode, path string) Option {
+	return func(o *Options) {
+		o.record = &recordOption{mode: mode, path: path}
+	}
+}
+
+// WithEndpointPool sends relative uri to endpoints of pool instead of BaseURI.
+func WithEndpointPool(p *EndpointPool) Option {
+	return func(o *Options) {
+		o.pool = p
+	}
+}
+
+// ServiceName sets the service name which is carried by errors of the client.
+func ServiceName(name string) Option {
+	return func(o *Options) {
+		o.service = name
+	}
+}
+
+// WithEnvelope checks the business envelope of every buffered response,
+// non-success codes are returned as *BusinessError together with the response.
+func WithEnvelope(conf EnvelopeConfig) Option {
+	return func(o *Options) {
+		conf.checkConf()
+		o.envelope = &conf
+	}
+}
+
+// WithRouter propagates route headers of the incoming request and routes the service by them, see Router.
+func WithRouter(rt *Router) Option {
+	return func(o *Options) {
+		o.router = rt
+	}
+}
+
+// CompressRequest compresses FormParams and JSON request bodies which are not smaller than conf.MinSize.
+func CompressRequest(conf CompressConfig) Option {
+	return func(o *Options) {
+		conf.checkConf()
+		o.compress = &conf
+	}
+}
+
+// MaxResponseSize limits the size of the buffered response body after decompression,
+// reading more fails with ErrResponseTooLarge, n <= 0 means no limit.
+func MaxResponseSize(n int64) Option {
+	return func(o *Options) {
+		o.maxRespSize = n
+	}
+}
+
+// Sensitive keeps headers and bodies of requests and responses out of logs and debug output,
+// it is used for requests carrying credentials such as token requests. Url, status and latency are still logged.
+func Sensitive() Option {
+	return func(o *Options) {
+		o.sensitive = true
+	}
+}
+
+// Backoff sets the backoff function used between retries.
+func Backoff(fn BackoffFunc) Option {
+	return func(o *Options) {
+		o.backoff = fn
+	}
+}
+
+// MaxRetryAfter caps the upstream Retry-After used by the built-in backoff functions,
+// a longer Retry-After waits d, d <= 0 ignores Retry-After.
+func MaxRetryAfter(d time.Duration) Option {
+	return func(o *Options) {
+		o.maxRetryAfter = d
+	}
+}
+
 // Number of retries when making the request.
 func Retries(i int) Option {
 	return func(o *Options) {
 		o.retries = i
 	}
 }
+
+// Transport sets the shared transport used by the client.
+func Transport(rt http.RoundTripper) Option {
+	return func(o *Options) {
+		o.transport = rt
+	}
+}
+
+// WithTransportConfig builds a dedicated tuned transport for the client.
+func WithTransportConfig(conf TransportConfig) Option {
+	return func(o *Options) {
+		o.transport = NewTransport(conf)
+	}
+}
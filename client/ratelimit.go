@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitWait     = "wait"      // 等待令牌 受上下文和MaxWait限制
+	RateLimitFailFast = "fail_fast" // 没有令牌立即失败
+)
+
+// RateLimitConfig 本地令牌桶限流配置
+type RateLimitConfig struct {
+	QPS     float64       `json:"qps" yaml:"qps"`           // 每秒请求数
+	Burst   int           `json:"burst" yaml:"burst"`       // 桶容量 默认等于QPS
+	Mode    string        `json:"mode" yaml:"mode"`         // wait 或 fail_fast 默认 wait
+	MaxWait time.Duration `json:"max_wait" yaml:"max_wait"` // wait 模式下最长等待时间 0 只受上下文限制
+}
+
+func (c *RateLimitConfig) checkConf() {
+	if c.QPS <= 0 {
+		c.QPS = 100
+	}
+	if c.Burst <= 0 {
+		c.Burst = int(c.QPS)
+		if c.Burst < 1 {
+			c.Burst = 1
+		}
+	}
+	if c.Mode != RateLimitFailFast {
+		c.Mode = RateLimitWait
+	}
+}
+
+// RateLimiter 本地令牌桶限流器
+type RateLimiter struct {
+	conf RateLimitConfig
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter instances a token bucket rate limiter, zero fields of conf use defaults.
+func NewRateLimiter(conf RateLimitConfig) *RateLimiter {
+	conf.checkConf()
+	return &RateLimiter{
+		conf:   conf,
+		tokens: float64(conf.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Wrapper is the WrapperChain of the rate limiter, use it with Wrap(l.Wrapper).
+func (l *RateLimiter) Wrapper(next Wrapper) Wrapper {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		if err := l.wait(ctx); err != nil {
+			if req.Log() != nil {
+				req.Log().Warnf("client rate limited, host: %s, err: %v", req.GetRequest().URL.Host, err)
+			}
+			return nil, err
+		}
+
+		return next(ctx, req)
+	}
+}
+
+// Allow reports whether a request can be sent now, it takes a token if true.
+func (l *RateLimiter) Allow() bool {
+	_, ok := l.reserve(time.Now(), 0)
+	return ok
+}
+
+func (l *RateLimiter) wait(ctx context.Context) error {
+	maxWait := time.Duration(0)
+	if l.conf.Mode == RateLimitWait {
+		maxWait = l.conf.MaxWait
+		if deadline, ok := ctx.Deadline(); ok {
+			if d := time.Until(deadline); maxWait <= 0 || d < maxWait {
+				maxWait = d
+			}
+		} else if maxWait <= 0 {
+			maxWait = time.Duration(1<<63 - 1)
+		}
+	}
+
+	d, ok := l.reserve(time.Now(), maxWait)
+	if !ok {
+		return ErrRateLimited.Wrap(fmt.Sprintf("qps %v", l.conf.QPS))
+	}
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes a token and returns how long to wait for it, it fails when the wait exceeds maxWait.
+func (l *RateLimiter) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.conf.QPS
+	if l.tokens > float64(l.conf.Burst) {
+		l.tokens = float64(l.conf.Burst)
+	}
+	l.last = now
+
+	tokens := l.tokens - 1
+	var d time.Duration
+	if tokens < 0 {
+		d = time.Duration(-tokens / l.conf.QPS * float64(time.Second))
+	}
+	if d > maxWait {
+		return 0, false
+	}
+
+	l.tokens = tokens
+	return d, true
+}
+
+// cancel returns the reserved token when the caller gives up waiting.
+func (l *RateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens++
+	if l.tokens > float64(l.conf.Burst) {
+		l.tokens = float64(l.conf.Burst)
+	}
+}
@@ -0,0 +1,485 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	urlpkg "net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	RecordModeRecord = "record" // 请求真实上游 并把请求响应写入 cassette 文件
+	RecordModeReplay = "replay" // 只从 cassette 文件返回响应 不访问网络
+)
+
+// RedactValue 脱敏后写入 cassette 的值
+const RedactValue = "[REDACTED]"
+
+// RedactHeaders 录制时脱敏的header 请求和响应都会脱敏 可以按需修改
+var RedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Signature", "X-Api-Key"}
+
+// RedactFields 录制时脱敏的 query 表单 和json顶层字段 回放时请求按同样的规则脱敏后匹配
+var RedactFields = []string{"client_secret", "password", "access_token", "refresh_token", "secret", "sign", "signature"}
+
+// Recorder 录制回放 transport cassette 文件为 HAR 格式
+// 回放时按 method url query body 匹配 匹配不到直接返回 ErrCassetteMiss
+type Recorder struct {
+	mode string
+	path string
+	next http.RoundTripper
+
+	mu      sync.Mutex
+	har     harFile
+	used    []bool
+	loadErr error
+}
+
+// NewRecorder instances a recorder, in replay mode the cassette is loaded from path.
+func NewRecorder(mode, path string, next http.RoundTripper) (*Recorder, error) {
+	if next == nil {
+		next = DefaultTransport
+	}
+	r := &Recorder{
+		mode: mode,
+		path: path,
+		next: next,
+		har:  newHarFile(),
+	}
+
+	switch mode {
+	case RecordModeRecord:
+	case RecordModeReplay:
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("recorder load cassette err: %w", err)
+		}
+		if err = json.Unmarshal(content, &r.har); err != nil {
+			return nil, fmt.Errorf("recorder parse cassette %s err: %w", path, err)
+		}
+		r.used = make([]bool, len(r.har.Log.Entries))
+	default:
+		return nil, fmt.Errorf("recorder invalid mode: %s", mode)
+	}
+
+	return r, nil
+}
+
+// newRecorderTransport is used by Record option, load error is returned by every request.
+func newRecorderTransport(mode, path string, next http.RoundTripper) http.RoundTripper {
+	r, err := NewRecorder(mode, path, next)
+	if err != nil {
+		return &Recorder{loadErr: err}
+	}
+	return r
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.loadErr != nil {
+		return nil, r.loadErr
+	}
+
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.mode == RecordModeReplay {
+		return r.replay(req, body)
+	}
+	return r.record(req, body)
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 优先使用未回放过的记录 相同请求多次录制时按顺序返回
+	matched := -1
+	for i, entry := range r.har.Log.Entries {
+		if !entry.Request.match(req, body) {
+			continue
+		}
+		if !r.used[i] {
+			matched = i
+			break
+		}
+		matched = i
+	}
+	if matched < 0 {
+		return nil, ErrCassetteMiss.Wrap(fmt.Sprintf("%s %s in %s", req.Method, req.URL.String(), r.path))
+	}
+	r.used[matched] = true
+
+	return r.har.Log.Entries[matched].Response.toResponse(req)
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	start := time.Now()
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	entry := harEntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		Time:            float64(time.Since(start).Microseconds()) / 1000,
+		Request:         newHarRequest(req, body),
+		Response:        newHarResponse(resp, respBody),
+	}
+	entry.Timings.Wait = entry.Time
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.har.Log.Entries = append(r.har.Log.Entries, entry)
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// save writes cassette to a temp file and renames it, caller must hold the lock.
+func (r *Recorder) save() error {
+	content, err := json.MarshalIndent(r.har, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(r.path); dir != "" {
+		if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	tmp := r.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// readRequestBody reads body and restores it so that it can be sent again.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// HAR 1.2 格式 只包含回放需要的字段
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"` // HAR 的 postData 没有 encoding 非文本内容使用 base64 并记为 base64
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func newHarFile() harFile {
+	return harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "pp-common/client", Version: "1.0"},
+		Entries: []harEntry{},
+	}}
+}
+
+func newHarRequest(req *http.Request, body []byte) harRequest {
+	hr := harRequest{
+		Method:      req.Method,
+		URL:         redactURL(req.URL),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(req.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	for k, vs := range redactValues(req.URL.Query()) {
+		for _, v := range vs {
+			hr.QueryString = append(hr.QueryString, harNameValue{Name: k, Value: v})
+		}
+	}
+	if body != nil {
+		text, encoding := encodeHarText(redactBody(req.Header.Get("Content-Type"), body))
+		hr.PostData = &harPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     text,
+			Comment:  encoding,
+		}
+	}
+	return hr
+}
+
+func newHarResponse(resp *http.Response, body []byte) harResponse {
+	text, encoding := encodeHarText(redactBody(resp.Header.Get("Content-Type"), body))
+	return harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(resp.Header),
+		Content: harContent{
+			Size:     len(body),
+			MimeType: resp.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+		},
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for k, vs := range header {
+		redact := isRedacted(RedactHeaders, k)
+		for _, v := range vs {
+			if redact {
+				v = RedactValue
+			}
+			headers = append(headers, harNameValue{Name: k, Value: v})
+		}
+	}
+	return headers
+}
+
+// isRedacted reports whether name is in names, case insensitive.
+func isRedacted(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValues returns a copy of values with RedactFields redacted.
+func redactValues(values urlpkg.Values) urlpkg.Values {
+	redacted := make(urlpkg.Values, len(values))
+	for k, vs := range values {
+		if isRedacted(RedactFields, k) {
+			vs = []string{RedactValue}
+		}
+		redacted[k] = vs
+	}
+	return redacted
+}
+
+// redactURL returns the url with RedactFields in query redacted, the url is kept as is if there is none.
+func redactURL(u *urlpkg.URL) string {
+	query := u.Query()
+	for k := range query {
+		if isRedacted(RedactFields, k) {
+			ru := *u
+			ru.RawQuery = redactValues(query).Encode()
+			return ru.String()
+		}
+	}
+	return u.String()
+}
+
+// redactBody redacts RedactFields of form body and top level fields of json object body,
+// other bodies and bodies without such fields are returned as is.
+func redactBody(contentType string, body []byte) []byte {
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		values, err := urlpkg.ParseQuery(string(body))
+		if err != nil {
+			return body
+		}
+		for k := range values {
+			if isRedacted(RedactFields, k) {
+				return []byte(redactValues(values).Encode())
+			}
+		}
+	case strings.Contains(contentType, "json"):
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(body, &fields) != nil {
+			return body
+		}
+		redacted := false
+		for k := range fields {
+			if isRedacted(RedactFields, k) {
+				fields[k] = json.RawMessage(strconv.Quote(RedactValue))
+				redacted = true
+			}
+		}
+		if redacted {
+			if content, err := json.Marshal(fields); err == nil {
+				return content
+			}
+		}
+	}
+	return body
+}
+
+func encodeHarText(body []byte) (text, encoding string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func decodeHarText(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+// normalizeBoundary replaces the random boundary of multipart body with a fixed one,
+// so that the same parts sent in different runs are equal.
+func normalizeBoundary(contentType string, body []byte) []byte {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return body
+	}
+	return bytes.ReplaceAll(body, []byte(params["boundary"]), []byte("boundary"))
+}
+
+// match compares method, url without query, query values and body, the request is redacted as recorded.
+// The boundary of multipart body is ignored.
+func (hr harRequest) match(req *http.Request, body []byte) bool {
+	if hr.Method != req.Method {
+		return false
+	}
+	u, err := urlpkg.Parse(hr.URL)
+	if err != nil {
+		return false
+	}
+	if u.Scheme != req.URL.Scheme || u.Host != req.URL.Host || u.Path != req.URL.Path {
+		return false
+	}
+	if q, rq := u.Query(), redactValues(req.URL.Query()); (len(q) > 0 || len(rq) > 0) && !reflect.DeepEqual(q, rq) {
+		return false
+	}
+
+	var recorded []byte
+	if hr.PostData != nil {
+		if recorded, err = decodeHarText(hr.PostData.Text, hr.PostData.Comment); err != nil {
+			return false
+		}
+		recorded = normalizeBoundary(hr.PostData.MimeType, recorded)
+	}
+	contentType := req.Header.Get("Content-Type")
+	return bytes.Equal(recorded, normalizeBoundary(contentType, redactBody(contentType, body)))
+}
+
+func (hr harResponse) toResponse(req *http.Request) (*http.Response, error) {
+	body, err := decodeHarText(hr.Content.Text, hr.Content.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header)
+	for _, h := range hr.Headers {
+		header.Add(h.Name, h.Value)
+	}
+
+	proto := hr.HTTPVersion
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	major, minor, _ := http.ParseHTTPVersion(proto)
+
+	return &http.Response{
+		Status:        strings.TrimSpace(fmt.Sprintf("%d %s", hr.Status, hr.StatusText)),
+		StatusCode:    hr.Status,
+		Proto:         proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "cassette.har")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("x-server", "real")
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.RawQuery, body)
+	}))
+
+	rec := NewClient(BaseURI(ts.URL), Record(RecordModeRecord, cassette))
+	get := Options{Query: map[string]interface{}{"a": "1", "b": "2"}}
+	post := Options{JSON: map[string]string{"k": "v"}}
+	upload := Options{Multipart: []Part{{Name: "file", FileName: "a.csv", Value: "id,amount"}}}
+
+	resp, err := rec.Get("/get", get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.GetBody()
+	if resp, err = rec.Post("/post", post); err != nil {
+		t.Fatal(err)
+	}
+	resp.GetBody()
+	if resp, err = rec.Post("/upload", upload); err != nil {
+		t.Fatal(err)
+	}
+	resp.GetBody()
+	ts.Close()
+
+	// 上游已关闭 只能从cassette回放
+	replay := NewClient(BaseURI(ts.URL), Record(RecordModeReplay, cassette))
+
+	resp, err = replay.Get("/get", Options{Query: "b=2&a=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := resp.GetBody()
+	if body.String() != "GET a=1&b=2 " || resp.GetHeader("x-server")[0] != "real" {
+		t.Fatalf("unexpected replay response %q %v", body, resp.GetHeaders())
+	}
+
+	resp, err = replay.Post("/post", post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := resp.GetBody(); body.String() != `POST  {"k":"v"}` {
+		t.Fatalf("unexpected replay response %q", body)
+	}
+
+	// multipart 每次的 boundary 不同 不影响匹配
+	if _, err = replay.Post("/upload", upload); err != nil {
+		t.Fatalf("multipart body should match with another boundary: %v", err)
+	}
+
+	_, err = replay.Post("/post", Options{JSON: map[string]string{"k": "other"}})
+	if errorCode(err) != CodeCassetteMiss {
+		t.Fatalf("unmatched body should fail, got %v", err)
+	}
+	_, err = replay.Get("/missing")
+	if errorCode(err) != CodeCassetteMiss {
+		t.Fatalf("unmatched url should fail, got %v", err)
+	}
+}
+
+func TestRecorder_MissingCassette(t *testing.T) {
+	cli := NewClient(Record(RecordModeReplay, filepath.Join(t.TempDir(), "missing.har")))
+	if _, err := cli.Get("http://example.com/"); err == nil {
+		t.Fatal("missing cassette should fail every request")
+	}
+}
+
+func TestRecorder_Redact(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "cassette.har")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=s3cr3t-cookie")
+		fmt.Fprintf(w, `{"access_token":"s3cr3t-token","expires_in":3600}`)
+	}))
+
+	token := Options{
+		Headers:    map[string]interface{}{"Authorization": "Basic s3cr3t-basic"},
+		Query:      map[string]interface{}{"password": "s3cr3t-query"},
+		FormParams: map[string]interface{}{"grant_type": "client_credentials", "client_secret": "s3cr3t-form"},
+	}
+	resp, err := NewClient(BaseURI(ts.URL), Record(RecordModeRecord, cassette)).Post("/token", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.GetBody()
+	ts.Close()
+
+	content, err := ioutil.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "s3cr3t") {
+		t.Fatalf("cassette should not contain secrets:\n%s", content)
+	}
+
+	// 回放时请求按同样的规则脱敏后匹配
+	resp, err = NewClient(BaseURI(ts.URL), Record(RecordModeReplay, cassette)).Post("/token", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := resp.GetBody(); body.String() != `{"access_token":"[REDACTED]","expires_in":3600}` {
+		t.Fatalf("unexpected replay response %q", body)
+	}
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/yulecd/pp-common/config"
+	"github.com/yulecd/pp-common/plog"
+)
+
+// Registry 按配置创建的具名client 配置修改后重新创建全部client 一次性替换
+// 新配置有错误时保留原来的client
+type Registry struct {
+	name string
+	opts []Option
+
+	reloadMu sync.Mutex // 同一时间只有一次重新加载
+	mu       sync.RWMutex
+	clients  map[string]*Request
+}
+
+// NewRegistry creates clients of every service in config name and rebuilds them when the config changes,
+// opt is applied to every client after the config. An error is returned if any service is invalid.
+func NewRegistry(name string, opt ...Option) (*Registry, error) {
+	r := &Registry{
+		name: name,
+		opts: opt,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	var cm map[string]Config
+	err := config.LoadWithCallback(name, &cm, func(string) {
+		if err := r.Reload(); err != nil {
+			plog.Errorf(nil, "client reload %s err: %v, old clients are kept", name, err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Get returns the client of service, nil if the service is not configured.
+func (r *Registry) Get(service string) *Request {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.clients[service]
+}
+
+// Reload rebuilds all clients from the config, clients are replaced only if every service is valid.
+// Calls in flight keep using the old clients.
+func (r *Registry) Reload() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	var cm map[string]Config
+	if err := config.Load(r.name, &cm); err != nil {
+		return err
+	}
+
+	services := make([]string, 0, len(cm))
+	for service := range cm {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	// 先校验全部服务 校验没有副作用 有错误时原来的client和共享的连接池等都不受影响
+	for _, service := range services {
+		c := cm[service]
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("client %s config error: %w", service, err)
+		}
+	}
+
+	// 全部服务的共享对象在一个事务里创建 有错误时回滚 已经在用的对象不会被替换或释放
+	tx := beginShared(false)
+	clients := make(map[string]*Request, len(cm))
+	for _, service := range services {
+		cOpt, err := cm[service].options(service)
+		if err != nil {
+			tx.rollback()
+			return fmt.Errorf("client %s config error: %w", service, err)
+		}
+
+		nOpt := make([]Option, 0, len(cOpt)+len(r.opts)+2)
+		nOpt = append(nOpt, ServiceName(service))
+		if router := getRouter(); router != nil {
+			nOpt = append(nOpt, WithRouter(router))
+		}
+		nOpt = append(nOpt, cOpt...)
+		nOpt = append(nOpt, r.opts...)
+		clients[service] = newHttpClient(nOpt...)
+	}
+
+	replaced := tx.commit()
+
+	r.mu.Lock()
+	r.clients = clients
+	r.mu.Unlock()
+
+	// 替换之后再释放旧的连接池 证书监听等
+	for _, v := range replaced {
+		releaseShared(v)
+	}
+
+	return nil
+}
+
+var (
+	registry   *Registry
+	registryMu sync.Mutex
+)
+
+// InitHttpClients creates clients of ServiceConfigName at startup, an error is returned if any service is invalid.
+// Clients are rebuilt when the config changes, use Get to get them.
+func InitHttpClients(opt ...Option) error {
+	r, err := NewRegistry(ServiceConfigName, opt...)
+	if err != nil {
+		return err
+	}
+
+	registryMu.Lock()
+	registry = r
+	registryMu.Unlock()
+	return nil
+}
+
+// Get returns the client of service configured in ServiceConfigName, nil if the service is not configured.
+// The clients are created on first use if InitHttpClients is not called, errors are logged then.
+func Get(service string) *Request {
+	registryMu.Lock()
+	if registry == nil {
+		r, err := NewRegistry(ServiceConfigName)
+		if err != nil {
+			registryMu.Unlock()
+			plog.Errorf(nil, "client init %s err: %v", ServiceConfigName, err)
+			return nil
+		}
+		registry = r
+	}
+	r := registry
+	registryMu.Unlock()
+
+	return r.Get(service)
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yulecd/pp-common/config"
+)
+
+func TestRegistry_Reload(t *testing.T) {
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(20 * time.Millisecond)
+			fmt.Fprintf(w, "%s|%s", name, r.Header.Get("x-app"))
+		}))
+	}
+	old, next := newServer("old"), newServer("new")
+	defer old.Close()
+	defer next.Close()
+
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte("service:\n"+content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service := func(uri, app string, idle int) string {
+		return fmt.Sprintf("  payment:\n    base_uri: %s\n    timeout: 1000\n    headers:\n      x-app: %s\n"+
+			"    transport:\n      max_idle_conns: %d\n", uri, app, idle)
+	}
+	write(service(old.URL, "a", 10))
+	if _, err := config.NewConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err := NewRegistry(ServiceConfigName, Retries(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.Get("user") != nil {
+		t.Fatal("unknown service should be nil")
+	}
+	get := func() string {
+		resp, err := reg.Get("payment").Get("/")
+		if err != nil {
+			return err.Error()
+		}
+		body, _ := resp.GetBody()
+		return string(body)
+	}
+	// timeout 单位毫秒 1000 足够等待上游
+	if body := get(); body != "old|a" {
+		t.Fatalf("unexpected response %s", body)
+	}
+
+	// 回调按注册顺序执行 收到通知时 registry 已经处理完这次修改
+	reloaded := make(chan struct{}, 8)
+	var cm map[string]Config
+	if err = config.LoadWithCallback(ServiceConfigName, &cm, func(string) { reloaded <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	// 有错误的配置不生效
+	write(service(next.URL, "b", 20) + "  user:\n    base_uri: not-a-uri\n")
+	select {
+	case <-reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("config change should be notified")
+	}
+	if body := get(); body != "old|a" {
+		t.Fatalf("invalid config should keep old clients, got %s", body)
+	}
+	// 共享的连接池也不能被替换
+	shared.Lock()
+	idle := shared.m["transport/payment"].conf.(TransportConfig).MaxIdleConns
+	shared.Unlock()
+	if idle != 10 {
+		t.Fatalf("invalid config should keep old transport, got max_idle_conns %d", idle)
+	}
+
+	write(service(next.URL, "b", 20))
+	waitFor(t, func() bool {
+		return get() == "new|b"
+	})
+}
+
+func TestRegistry_Validate(t *testing.T) {
+	ts := newEchoServer()
+	defer ts.Close()
+
+	for _, service := range []string{
+		"endpoints:\n      - uri: 10.0.0.1:8080\n",
+		"sign:\n      algorithm: rsa-sha256\n      private_key_file: /no/such/key.pem\n",
+		"sign:\n      secret: \"\"\n",
+		"transport:\n      tls:\n        ca_file: /no/such/ca.crt\n",
+		"transport:\n      tls:\n        min_version: \"1.4\"\n",
+		"transport:\n      proxy:\n        url: ftp://1.1.1.1\n",
+	} {
+		path := filepath.Join(t.TempDir(), "app.yaml")
+		content := "service:\n  payment:\n    " + service
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := config.NewConfig(path); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := NewRegistry(ServiceConfigName); err == nil {
+			t.Fatalf("invalid config should fail at startup: %s", service)
+		}
+		// 签名 认证等配置错误时不能不签名就发出请求
+		if _, err := NewHttpClientWithConfig(ServiceConfigName, "payment").Get(ts.URL); err == nil {
+			t.Fatalf("client of invalid config should fail every call: %s", service)
+		}
+	}
+	if _, err := NewRegistry("no_such_config"); err == nil {
+		t.Fatal("missing config should fail at startup")
+	}
+}
@@ -8,10 +8,12 @@ import (
 	"fmt"
 	"io"
 	logpkg "log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	urlpkg "net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/yulecd/pp-common/plog"
@@ -19,14 +21,26 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Request 一次api请求
+// DefaultClient/NewClient 返回的Request只持有上下文和不可变的HttpClient 可以并发使用
+// 每次调用 Get/Post 等方法都会生成一个新的Request 保存本次调用的选项和http请求 互不影响
 type Request struct {
-	ctx  context.Context
-	opts Options
-	cli  *http.Client
-	req  *http.Request
-	body io.Reader
-
-	startTime time.Time
+	ctx    context.Context
+	client *HttpClient
+	opts   Options
+	req    *http.Request
+	body   io.Reader
+	stream *streamBody // Body 或 Multipart 的流式请求体
+
+	startTime   time.Time
+	sent        bool          // 请求是否已发送过 再次发送前需要重置body
+	lastResp    *Response     // 最近一次尝试的响应
+	lastBackoff time.Duration // 最近一次重试等待时间
+	endpoint    *Endpoint     // 配置了多个节点时 当前请求的节点
+	hedged      bool          // 对冲发出的请求 不再计入重试预算的请求数
+	decompress  bool          // 声明了默认的 Accept-Encoding 需要解压响应
+	signer      *Signer       // 每次尝试发送前签名
+	plainBody   []byte        // 压缩前的请求体 只用于打印日志
 }
 
 func NewRequest(req *http.Request) *Request {
@@ -35,10 +49,18 @@ func NewRequest(req *http.Request) *Request {
 	}
 }
 
-func (r *Request) WithContext(context context.Context) *Request {
-	r.ctx = context
+// WithContext returns a shallow copy of r with its context changed to ctx, r itself is not modified.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+
+	return r2
+}
 
-	return r
+// Client returns the immutable client which r sends requests with.
+func (r *Request) Client() *HttpClient {
+	return r.client
 }
 
 // Log 从通用的请求上下文里获取日志对象
@@ -87,26 +109,49 @@ func (r *Request) Options(uri string, opts ...Options) (*Response, error) {
 
 // doRequest is last wrapper and execute request.Do
 var doRequest = func(ctx context.Context, req *Request) (*Response, error) {
-	return req.sendRequest()
+	return req.sendRequest(ctx)
 }
 
 // Request encapsulates http.request internal logic
+// r itself is never modified, every call is built on a new Request.
 func (r *Request) Request(method, uri string, opts ...Options) (*Response, error) {
-	r.startTime = time.Now()
-	defaultOpts := r.opts
+	if r.client == nil {
+		return nil, errors.New("invalid request: no client")
+	}
+
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	call := &Request{
+		ctx:       ctx,
+		client:    r.client,
+		opts:      r.client.opts.clone(),
+		startTime: time.Now(),
+	}
 	if len(opts) > 0 {
-		r.opts.merge(opts[0])
+		call.opts.merge(opts[0])
 	}
 
+	return call.do(method, uri)
+}
+
+// do builds http request and sends it through wrappers
+func (r *Request) do(method, uri string) (*Response, error) {
+	if r.opts.router != nil {
+		r.opts.router.route(r)
+	}
 	if false == IsValidHttpUrl(uri) {
-		baseUri := defaultOpts.BaseURI
-		if r.opts.BaseURI != "" {
-			baseUri = r.opts.BaseURI
-		}
-		if baseUri == "" {
+		switch {
+		case r.opts.pool != nil:
+			r.endpoint = r.opts.pool.Pick(nil)
+			uri = r.endpoint.URI + uri
+		case r.opts.BaseURI == "":
 			return nil, errors.New("invalid uri: empty uri")
+		default:
+			uri = r.opts.BaseURI + uri
 		}
-		uri = baseUri + uri
 	}
 
 	switch method {
@@ -118,7 +163,13 @@ func (r *Request) Request(method, uri string, opts ...Options) (*Response, error
 
 		r.req = req
 	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodOptions:
-		r.parseBody()
+		if err := r.parseBody(); err != nil {
+			return nil, err
+		}
+		encoding, err := r.compressBody()
+		if err != nil {
+			return nil, err
+		}
 
 		req, err := http.NewRequest(method, uri, r.body)
 		if err != nil {
@@ -126,7 +177,14 @@ func (r *Request) Request(method, uri string, opts ...Options) (*Response, error
 		}
 
 		r.req = req
-		if r.opts.FormParams != nil {
+		if encoding != "" {
+			r.req.Header.Set("Content-Encoding", encoding)
+		}
+		if r.stream != nil {
+			if err := r.stream.apply(r.req); err != nil {
+				return nil, err
+			}
+		} else if r.opts.FormParams != nil {
 			r.req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		} else if r.opts.JSON != nil {
 			r.req.Header.Set("Content-Type", "application/json")
@@ -135,12 +193,11 @@ func (r *Request) Request(method, uri string, opts ...Options) (*Response, error
 		return nil, errors.New("invalid request method")
 	}
 
-	r.mergeDefaultOpts(defaultOpts)
-	r.parseClient()
 	r.parseQuery()
 	r.parseHeaders()
+	r.acceptEncoding()
 
-	dump, err := httputil.DumpRequest(r.req, true)
+	dump, err := r.dumpRequest()
 	if r.Log() != nil {
 		r.Log().WithFields(logrus.Fields{
 			"host":   r.req.URL.String(),
@@ -158,87 +215,377 @@ func (r *Request) Request(method, uri string, opts ...Options) (*Response, error
 		}
 	}
 
-	return root(r.ctx, r)
+	// 整体超时覆盖所有重试 响应体关闭后才释放
+	ctx, cancel := r.ctx, context.CancelFunc(func() {})
+	if r.opts.timeout > 0 {
+		ctx, cancel = context.WithTimeout(r.ctx, r.opts.timeout)
+	}
+
+	resp, err := root(ctx, r)
+	if err != nil || resp == nil || resp.hresp == nil || resp.hresp.Body == nil {
+		cancel()
+		err = r.wrapError(err)
+		r.logResponse(resp, err)
+		return resp, err
+	}
+	resp.hresp.Body = &cancelBody{ReadCloser: resp.hresp.Body, cancel: cancel}
+
+	if !r.opts.Stream {
+		// 读取完整响应后关闭 连接马上归还连接池 调用方不需要关闭
+		if err = resp.buffer(); err != nil {
+			err = r.wrapError(err)
+			r.logResponse(resp, err)
+			return nil, err
+		}
+
+		if r.opts.envelope != nil {
+			if err = resp.BusinessError(); err != nil {
+				r.logResponse(resp, err)
+				return resp, err
+			}
+		}
+	}
+	r.logResponse(resp, nil)
+
+	return resp, nil
 }
 
-func (r *Request) sendRequest() (resp *Response, err error) {
-	call := func(i int) error {
-		t, err := r.opts.backoff(r.ctx, r, i)
-		if err != nil {
-			return err
+// dumpRequest dumps the request for logs, stream bodies are not dumped to avoid reading uploaded files into memory,
+// compressed bodies are dumped before compression, sensitive requests are dumped without headers and body.
+func (r *Request) dumpRequest() ([]byte, error) {
+	if r.opts.sensitive {
+		return []byte(r.req.Method + " " + r.req.URL.String()), nil
+	}
+
+	dump, err := httputil.DumpRequest(r.req, r.stream == nil && r.plainBody == nil)
+	if err == nil && r.plainBody != nil {
+		dump = append(dump, r.plainBody...)
+	}
+	return dump, err
+}
+
+// logResponse logs status and latency of every call, body is only logged when it is buffered.
+func (r *Request) logResponse(resp *Response, err error) {
+	if r.Log() == nil {
+		return
+	}
+
+	fields := logrus.Fields{
+		"host":   r.req.URL.String(),
+		"method": r.req.Method,
+		"cost":   float64(time.Since(r.startTime).Microseconds()) / 1000,
+	}
+	if resp != nil && resp.hresp != nil {
+		fields["status"] = resp.hresp.StatusCode
+	}
+	if resp == nil {
+		// 出错时记录最后一次尝试的耗时
+		resp = r.lastResp
+	}
+	if resp != nil && resp.timing != nil {
+		for k, v := range resp.timing.snapshot().fields() {
+			fields[k] = v
 		}
+	}
 
-		if t.Seconds() > 0 {
-			time.Sleep(t)
+	entry := r.Log().WithFields(fields)
+	switch {
+	case err != nil:
+		entry.Warnf("client resp err: %v", err)
+	case r.opts.sensitive:
+		entry.Infof("client resp: sensitive")
+	case r.opts.Stream:
+		entry.Infof("client resp: stream")
+	default:
+		entry.Infof("client resp: %s", string(resp.copyBody))
+	}
+}
+
+func (r *Request) sendRequest(ctx context.Context) (resp *Response, err error) {
+	if r.opts.budget != nil && !r.hedged {
+		r.opts.budget.deposit()
+	}
+
+	for i := 0; i < r.opts.retries; i++ {
+		// 第一次请求不等待 重试前按backoff等待
+		if i > 0 {
+			if serr := r.sleep(ctx, i); serr != nil {
+				return nil, r.wrapError(serr)
+			}
 		}
 
-		_resp, err := r.cli.Do(r.req)
+		resp, err = r.attempt(ctx)
+		r.lastResp = resp
 
-		resp = &Response{
-			req:   r,
-			hresp: _resp,
-			hreq:  r.req,
-			err:   err,
+		// 调用方取消或者整体超时 不再重试
+		if err != nil && ctx.Err() != nil {
+			return nil, r.wrapError(err)
 		}
 
-		if err != nil {
-			if r.opts.debug {
-				fmt.Println(err)
+		retry, rerr := r.opts.retry(ctx, r, resp, i, err)
+		if rerr != nil {
+			resp.discard()
+			return nil, r.wrapError(rerr)
+		}
+
+		if retry && i < r.opts.retries-1 && !r.rewindable() {
+			// 请求体已经被读取 无法再次发送
+			retry = false
+			if r.Log() != nil {
+				r.Log().Warnf("client retry skipped, request body can not be rewound: %s", r.req.URL.String())
+			}
+		}
+
+		if !retry {
+			if err != nil {
+				return nil, r.wrapError(err)
 			}
+			return resp, nil
 		}
 
-		if r.opts.debug && _resp != nil {
-			dump, err := httputil.DumpResponse(_resp, true)
-			if err == nil {
-				logpkg.Printf("\n%s", dump)
+		// 还有重试机会时释放本次响应的连接
+		if i < r.opts.retries-1 {
+			if r.opts.budget != nil && !r.opts.budget.withdraw() {
+				return r.budgetExhausted(resp, err)
 			}
+			resp.discard()
 		}
+	}
+
+	return resp, r.wrapError(err)
+}
+
+// budgetExhausted logs and returns the error when retry is rejected by the budget,
+// the last response is buffered and returned together so that callers can still see it.
+func (r *Request) budgetExhausted(resp *Response, err error) (*Response, error) {
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+	} else if resp != nil && resp.hresp != nil {
+		reason = resp.hresp.Status
+		if resp.buffer() != nil {
+			resp = nil
+		}
+	}
+
+	if r.Log() != nil {
+		r.Log().WithFields(logrus.Fields{
+			"host":   r.req.URL.String(),
+			"tokens": r.opts.budget.Tokens(),
+		}).Warnf("client retry budget exhausted: %s", reason)
+	}
+
+	return resp, ErrRetryBudgetExhausted.Wrap(reason)
+}
 
+// sleep waits backoff duration before the attempt, it returns early when ctx is done.
+func (r *Request) sleep(ctx context.Context, attempts int) error {
+	t, err := r.opts.backoff(ctx, r, attempts)
+	if err != nil {
 		return err
 	}
+	r.lastBackoff = t
+	if t <= 0 {
+		return nil
+	}
 
-	ch := make(chan error, r.opts.retries)
+	timer := time.NewTimer(t)
+	defer timer.Stop()
 
-	for i := 0; i < r.opts.retries; i++ {
-		go func() {
-			ch <- call(i)
-		}()
-
-		select {
-		case <-r.ctx.Done():
-			return nil, errors.New(fmt.Sprintf("http.client request timeout, err: %v", err))
-		case err = <-ch:
-			if err == nil {
-				return
-			}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
 
-			retry, rerr := r.opts.retry(r.ctx, r, resp, i, err)
-			if rerr != nil {
-				return nil, rerr
-			}
+// attempt sends the request once, bounded by ctx and the per attempt timeout.
+func (r *Request) attempt(ctx context.Context) (*Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if r.opts.attemptTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.opts.attemptTimeout)
+	}
 
-			if !retry {
-				return nil, err
-			}
+	if err := r.rewindBody(); err != nil {
+		cancel()
+		return &Response{req: r, hreq: r.req, err: err}, err
+	}
+
+	trace := newTimingTrace()
+	ctx = trace.withContext(ctx)
+	release := cancel
+	cancel = func() {
+		trace.finish()
+		release()
+	}
+
+	// 重试优先选择其他节点
+	if r.endpoint != nil && r.sent {
+		r.switchEndpoint(r.opts.pool.Pick(r.endpoint))
+	}
+	// 节点确定后再签名 每次尝试使用新的时间戳和随机数
+	if r.signer != nil {
+		if err := r.signer.Sign(r.req); err != nil {
+			cancel()
+			return &Response{req: r, hreq: r.req, err: err}, err
 		}
 	}
+	if r.endpoint != nil {
+		atomic.AddInt64(&r.endpoint.inflight, 1)
+	}
+
+	_resp, err := r.client.cli.Do(r.req.WithContext(ctx))
+	r.sent = true
+
+	if r.endpoint != nil {
+		atomic.AddInt64(&r.endpoint.inflight, -1)
+		r.reportEndpoint(ctx, _resp, err)
+	}
+
+	resp := &Response{
+		req:    r,
+		hresp:  _resp,
+		hreq:   r.req,
+		err:    err,
+		timing: trace,
+	}
+
+	if err != nil {
+		cancel()
+		if r.opts.debug {
+			fmt.Println(err)
+		}
+		return resp, err
+	}
+
+	if r.decompress {
+		decompressResponse(_resp)
+	}
+	if r.opts.debug {
+		dump, err := httputil.DumpResponse(_resp, !r.opts.sensitive)
+		if err == nil {
+			logpkg.Printf("\n%s", dump)
+		}
+	}
+
+	// 读取完响应后再释放本次尝试的上下文
+	_resp.Body = &cancelBody{ReadCloser: _resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+// rewindBody resets request body before it is sent again.
+func (r *Request) rewindBody() error {
+	if !r.sent || r.req.Body == nil || r.req.Body == http.NoBody {
+		return nil
+	}
+	if r.req.GetBody == nil {
+		return errors.New("request body can not be rewound for retry")
+	}
+	body, err := r.req.GetBody()
+	if err != nil {
+		return err
+	}
+	r.req.Body = body
+	return nil
+}
+
+// rewindable reports whether the request can be sent again.
+func (r *Request) rewindable() bool {
+	return r.req.Body == nil || r.req.Body == http.NoBody || r.req.GetBody != nil
+}
+
+// switchEndpoint moves the request to ep, path suffix and query are kept.
+func (r *Request) switchEndpoint(ep *Endpoint) {
+	if ep == r.endpoint {
+		return
+	}
 
-	return
+	u := *r.req.URL
+	u.Scheme = ep.base.Scheme
+	u.Host = ep.base.Host
+	u.Path = strings.TrimSuffix(ep.base.Path, "/") + strings.TrimPrefix(u.Path, strings.TrimSuffix(r.endpoint.base.Path, "/"))
+	u.RawPath = ""
+
+	r.req.URL = &u
+	r.req.Host = u.Host
+	r.endpoint = ep
 }
 
-func (r *Request) mergeDefaultOpts(opts Options) {
-	for k, v := range opts.Headers {
-		if _, ok := r.opts.Headers[k]; !ok {
-			r.opts.Headers[k] = v
+// reportEndpoint feeds attempt result to outlier ejection, cancellation by caller or hedging is ignored.
+func (r *Request) reportEndpoint(ctx context.Context, resp *http.Response, err error) {
+	if r.ctx.Err() != nil || ctx.Err() == context.Canceled {
+		return
+	}
+
+	success := err == nil && resp.StatusCode < http.StatusInternalServerError
+	if r.opts.pool.report(r.endpoint, success) && r.Log() != nil {
+		r.Log().Warnf("client endpoint ejected: %s", r.endpoint.URI)
+	}
+}
+
+// hedge returns a copy of r for one leg of hedged requests, r must not have body.
+// Hedged legs are not retried and go to another endpoint if there is one.
+func (r *Request) hedge(hedged bool) *Request {
+	h := new(Request)
+	*h = *r
+	h.req = r.req.Clone(r.req.Context())
+	h.hedged = hedged
+	if hedged {
+		h.opts.retries = 1
+		if h.endpoint != nil {
+			h.switchEndpoint(h.opts.pool.Pick(h.endpoint))
 		}
 	}
+	return h
+}
+
+// adopt takes the state of the hedged leg which returned.
+func (r *Request) adopt(leg *Request) {
+	r.req = leg.req
+	r.sent = leg.sent
+	r.lastResp = leg.lastResp
+	r.lastBackoff = leg.lastBackoff
+	r.endpoint = leg.endpoint
 }
 
-// parseClient setups request client
-func (r *Request) parseClient() {
-	r.cli = &http.Client{
-		Timeout: r.opts.timeout,
+// LastResponse returns response of the latest attempt, it is useful in BackoffFunc.
+func (r *Request) LastResponse() *Response {
+	return r.lastResp
+}
+
+// wrapError distinguishes caller cancellation from upstream timeout.
+func (r *Request) wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errorCode(err) != 0 {
+		return err
+	}
+	// 调用方取消 或者对冲时其他请求先返回被取消
+	if (r.ctx != nil && r.ctx.Err() != nil) || errors.Is(err, context.Canceled) {
+		return ErrCanceled.Wrap(err.Error())
 	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout.Wrap(err.Error())
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ErrTimeout.Wrap(err.Error())
+	}
+	return err
+}
+
+// cancelBody releases the request context when response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
 }
 
 // parseQuery parses request query
@@ -287,7 +634,15 @@ func (r *Request) parseHeaders() {
 }
 
 // parseBody parses request body
-func (r *Request) parseBody() {
+func (r *Request) parseBody() (err error) {
+	if r.opts.Multipart != nil {
+		r.stream, err = newMultipartBody(r.opts.Multipart, r.opts.FormParams)
+		return err
+	}
+	if r.opts.Body != nil {
+		r.stream, err = newReaderBody(r.opts.Body, r.opts.ContentType)
+		return err
+	}
 	if r.opts.FormParams != nil {
 		values := urlpkg.Values{}
 		for k, v := range r.opts.FormParams {
@@ -306,15 +661,15 @@ func (r *Request) parseBody() {
 			}
 		}
 		r.body = strings.NewReader(values.Encode())
-		return
+		return nil
 	}
 	if r.opts.JSON != nil {
 		b, err := json.Marshal(r.opts.JSON)
 		if err == nil {
 			r.body = bytes.NewReader(b)
-			return
+			return nil
 		}
 	}
 
-	return
+	return nil
 }
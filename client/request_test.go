@@ -0,0 +1,273 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newEchoServer echoes path, query and x-call header of request.
+func newEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s?%s|%s|%s", r.URL.Path, r.URL.RawQuery, r.Header.Get("x-call"), r.Header.Get("x-default"))
+	}))
+}
+
+func TestRequest_Concurrent(t *testing.T) {
+	ts := newEchoServer()
+	defer ts.Close()
+
+	cli := NewClient(BaseURI(ts.URL), func(o *Options) {
+		o.Headers = map[string]interface{}{"x-default": "d"}
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				call := fmt.Sprintf("%d-%d", g, i)
+				opts := Options{
+					Query:   map[string]interface{}{"call": call},
+					Headers: map[string]interface{}{"x-call": call},
+				}
+
+				var resp *Response
+				var err error
+				if i%2 == 0 {
+					resp, err = cli.Get("/get/"+call, opts)
+				} else {
+					opts.JSON = map[string]string{"call": call}
+					resp, err = cli.Post("/post/"+call, opts)
+				}
+				if err != nil {
+					t.Errorf("call %s error: %v", call, err)
+					return
+				}
+				body, err := resp.GetBody()
+				if err != nil {
+					t.Errorf("call %s body error: %v", call, err)
+					return
+				}
+
+				path := "/get/"
+				if i%2 == 1 {
+					path = "/post/"
+				}
+				expect := fmt.Sprintf("%s%s?call=%s|%s|d", path, call, call, call)
+				if body.String() != expect {
+					t.Errorf("call %s got %q, expect %q", call, body.String(), expect)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestRequest_OptionsNotLeak(t *testing.T) {
+	ts := newEchoServer()
+	defer ts.Close()
+
+	cli := NewClient(BaseURI(ts.URL))
+
+	resp, err := cli.Get("/a", Options{
+		Query:   "k=v",
+		Headers: map[string]interface{}{"x-call": "first"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := resp.GetBody(); body.String() != "/a?k=v|first|" {
+		t.Fatalf("unexpected first body %q", body)
+	}
+
+	resp, err = cli.Get("/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := resp.GetBody(); body.String() != "/b?||" {
+		t.Fatalf("per call options leaked into client: %q", body)
+	}
+}
+
+func TestRequest_WithContext(t *testing.T) {
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, 1)
+
+	cli := NewClient()
+	bound := cli.WithContext(ctx)
+	if bound == cli {
+		t.Fatal("WithContext should return a copy")
+	}
+	if cli.ctx == ctx || bound.ctx != ctx {
+		t.Fatal("WithContext should only change the copy")
+	}
+	if bound.Client() != cli.Client() {
+		t.Fatal("WithContext should share the same client")
+	}
+}
+
+func newSlowServer(delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			w.Write([]byte("ok"))
+		case <-r.Context().Done():
+		}
+	}))
+}
+
+func TestRequest_Timeout(t *testing.T) {
+	ts := newSlowServer(time.Second)
+	defer ts.Close()
+
+	start := time.Now()
+	_, err := NewClient(Timeout(time.Minute), AttemptTimeout(50*time.Millisecond)).Get(ts.URL)
+	if errorCode(err) != CodeTimeout {
+		t.Fatalf("expect attempt timeout, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("attempt timeout not honoured, cost %s", time.Since(start))
+	}
+
+	_, err = NewClient(Timeout(50 * time.Millisecond)).Get(ts.URL)
+	if errorCode(err) != CodeTimeout {
+		t.Fatalf("expect overall timeout, got %v", err)
+	}
+}
+
+func TestRequest_Canceled(t *testing.T) {
+	ts := newSlowServer(time.Second)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := NewClient(Retries(3)).WithContext(ctx).Get(ts.URL)
+	if errorCode(err) != CodeCanceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+}
+
+func TestRequest_BodyAfterReturn(t *testing.T) {
+	ts := newEchoServer()
+	defer ts.Close()
+
+	// 整体超时的上下文在读取响应体之前不能被释放
+	resp, err := NewClient(Timeout(time.Second), AttemptTimeout(time.Second)).Get(ts.URL + "/body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	body, err := resp.GetBody()
+	if err != nil || body.String() != "/body?||" {
+		t.Fatalf("read body after return: %q %v", body, err)
+	}
+}
+
+func TestResponse_AlwaysClosed(t *testing.T) {
+	ts, conns := newConnCountServer()
+	defer ts.Close()
+
+	// 不读取响应体 连接也要归还连接池
+	cli := NewClient(WithTransportConfig(TransportConfig{}))
+	for i := 0; i < 20; i++ {
+		resp, err := cli.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetStatusCode() != http.StatusOK {
+			t.Fatalf("unexpected status %d", resp.GetStatusCode())
+		}
+	}
+	if n := atomic.LoadInt64(conns); n != 1 {
+		t.Fatalf("connections should be reused, got %d", n)
+	}
+
+	resp, err := cli.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if body, err := resp.GetBody(); err != nil || body.String() != "ok" {
+			t.Fatalf("buffered body should be readable again: %q %v", body, err)
+		}
+	}
+
+	// Stream 时由调用方读取并关闭
+	resp, err = cli.Get(ts.URL, Options{Stream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := resp.BodyReader(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(body)
+	body.Close()
+	if string(content) != "ok" {
+		t.Fatalf("unexpected stream body %q", content)
+	}
+}
+
+func TestResponse_GetReasonPhrase(t *testing.T) {
+	cases := map[string]string{
+		"200 OK":                    "OK",
+		"503 Service Unavailable":   "Service Unavailable",
+		"200":                       "OK",
+		"599 ":                      "",
+		"418 I'm a teapot, really!": "I'm a teapot, really!",
+	}
+	for status, expect := range cases {
+		code, _ := strconv.Atoi(strings.Fields(status)[0])
+		resp := &Response{hresp: &http.Response{Status: status, StatusCode: code}}
+		if got := resp.GetReasonPhrase(); got != expect {
+			t.Errorf("status %q got reason %q, expect %q", status, got, expect)
+		}
+	}
+	if (&Response{}).GetReasonPhrase() != "" {
+		t.Error("response without http response should have empty reason")
+	}
+}
+
+func TestResponse_Timing(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	cli := NewClient(Transport(ts.Client().Transport))
+	resp, err := cli.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timing := resp.Timing()
+	if timing.Reused || timing.Connect <= 0 || timing.TLS <= 0 {
+		t.Fatalf("first call should dial a new connection: %+v", timing)
+	}
+	if timing.FirstByte < 20*time.Millisecond || timing.Total < timing.FirstByte {
+		t.Fatalf("first byte should include server time: %+v", timing)
+	}
+
+	resp, err = cli.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timing = resp.Timing(); !timing.Reused || timing.Connect != 0 || timing.TLS != 0 {
+		t.Fatalf("second call should reuse the connection: %+v", timing)
+	}
+
+	if (*Response)(nil).Timing() != (Timing{}) || (&Response{}).Timing() != (Timing{}) {
+		t.Fatal("response without attempt should have zero timing")
+	}
+}
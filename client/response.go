@@ -1,16 +1,18 @@
 package client
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/sirupsen/logrus"
-
 	jsoniter "github.com/json-iterator/go"
 )
 
@@ -21,6 +23,12 @@ type Response struct {
 	hreq     *http.Request
 	err      error
 	copyBody []byte
+	bodyErr  error        // 读取响应体的错误 wrapper 里读取失败后不会再读
+	timing   *timingTrace // 本次尝试的耗时
+	cached   bool         // 来自缓存 没有发出请求或者服务端返回了304
+
+	envelope    *Envelope // 解析过的业务响应
+	envelopeErr error
 }
 
 type ResponseBody []byte
@@ -57,47 +65,199 @@ func (r *Response) GetHttpRequest() *http.Request {
 	return r.hreq
 }
 
-// GetBody returns response body
+// GetBody returns response body, it is buffered when the request returns unless Options.Stream is set.
 func (r *Response) GetBody() (ResponseBody, error) {
+	if r.copyBody != nil {
+		// 已经读取过
+		return ResponseBody(r.copyBody), nil
+	}
+	if r.hresp == nil || r.hresp.Body == nil {
+		return nil, errors.New("response has no body")
+	}
+
 	defer r.hresp.Body.Close()
-	var body []byte
-	var err error
+	body, err := r.readBody()
+	if err != nil {
+		return nil, err
+	}
+	r.copyBody = body
+	r.hresp.Body = ioutil.NopCloser(bytes.NewReader(body))
 
-	if r.copyBody != nil {
-		// 重复读取
-		body = r.copyBody
-	} else {
-		// 第一次读取
-		body, err = ioutil.ReadAll(r.hresp.Body)
+	return ResponseBody(body), nil
+}
+
+// buffer reads the whole body and closes it so that the connection is released immediately,
+// the body can still be read by GetBody and BodyReader.
+func (r *Response) buffer() error {
+	// wrapper 里可能已经读取过
+	if r.copyBody == nil {
+		body, err := r.readBody()
 		if err != nil {
-			return nil, err
+			r.hresp.Body.Close()
+			return err
 		}
-		if body != nil {
-			r.copyBody = body
+		r.copyBody = body
+	}
+
+	r.hresp.Body.Close()
+	r.hresp.Body = ioutil.NopCloser(bytes.NewReader(r.copyBody))
+	return nil
+}
+
+// readBody reads the whole body within the MaxResponseSize of the request, the error is kept for later reads.
+func (r *Response) readBody() ([]byte, error) {
+	if r.bodyErr != nil {
+		return nil, r.bodyErr
+	}
+
+	var limit int64
+	if r.req != nil {
+		limit = r.req.opts.maxRespSize
+	}
+	body := r.hresp.Body
+	if limit > 0 {
+		if body, r.bodyErr = r.BodyReader(limit); r.bodyErr != nil {
+			return nil, r.bodyErr
 		}
 	}
 
-	if r.req.Log() != nil {
-		r.req.Log().WithFields(logrus.Fields{
-			"host": r.req.req.URL.String(),
-			"cost": float64(time.Now().Sub(r.req.startTime).Microseconds()) / 1000,
-		}).Infof("client resp: %s", string(body))
+	var data []byte
+	data, r.bodyErr = ioutil.ReadAll(body)
+	return data, r.bodyErr
+}
+
+// BodyReader returns the response body for streaming without buffering it, caller must close it.
+// Reading more than limit bytes fails with ErrResponseTooLarge, limit <= 0 means no limit.
+func (r *Response) BodyReader(limit int64) (io.ReadCloser, error) {
+	if r.hresp == nil || r.hresp.Body == nil {
+		return nil, errors.New("response has no body")
+	}
+	if limit <= 0 {
+		return r.hresp.Body, nil
+	}
+	if r.hresp.ContentLength > limit {
+		r.hresp.Body.Close()
+		return nil, ErrResponseTooLarge.Wrap(fmt.Sprintf("content length %d exceeds %d", r.hresp.ContentLength, limit))
 	}
 
-	return ResponseBody(body), nil
+	return &limitedBody{ReadCloser: r.hresp.Body, limit: limit, remain: limit}, nil
 }
 
-// GetStatusCode returns response code
+// limitedBody fails instead of truncating when body exceeds the limit
+type limitedBody struct {
+	io.ReadCloser
+	limit  int64
+	remain int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remain <= 0 {
+		// 已经读到上限 再多一个字节就是超限
+		var one [1]byte
+		n, err := b.ReadCloser.Read(one[:])
+		if n > 0 {
+			return 0, ErrResponseTooLarge.Wrap(fmt.Sprintf("body exceeds %d", b.limit))
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remain {
+		p = p[:b.remain]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remain -= int64(n)
+	return n, err
+}
+
+// discard drains and closes body so that the connection can be reused.
+func (r *Response) discard() {
+	if r == nil || r.hresp == nil || r.hresp.Body == nil {
+		return
+	}
+	io.CopyN(ioutil.Discard, r.hresp.Body, 4<<10)
+	r.hresp.Body.Close()
+}
+
+// RetryAfter parses Retry-After header in seconds or http date format.
+func (r *Response) RetryAfter() (time.Duration, bool) {
+	if r == nil || r.hresp == nil {
+		return 0, false
+	}
+	value := r.hresp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// syntheticResponse builds a response for req which is not received from upstream.
+func syntheticResponse(req *Request, status int, header http.Header, body []byte) *Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	hreq := req.GetRequest()
+	return &Response{
+		req:  req,
+		hreq: hreq,
+		hresp: &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       hreq,
+		},
+	}
+}
+
+// FromCache reports whether r is served from Cache.
+func (r *Response) FromCache() bool {
+	return r != nil && r.cached
+}
+
+// Timing returns the timing breakdown of the attempt which produced r,
+// Total is final once the body is read or closed.
+func (r *Response) Timing() Timing {
+	if r == nil {
+		return Timing{}
+	}
+	return r.timing.snapshot()
+}
+
+// GetStatusCode returns response code, 0 if there is no response.
 func (r *Response) GetStatusCode() int {
+	if r == nil || r.hresp == nil {
+		return 0
+	}
 	return r.hresp.StatusCode
 }
 
-// GetReasonPhrase returns response reason phrase
+// GetReasonPhrase returns response reason phrase, the standard text is used when status line has none.
 func (r *Response) GetReasonPhrase() string {
-	status := r.hresp.Status
-	arr := strings.Split(status, " ")
+	if r == nil || r.hresp == nil {
+		return ""
+	}
+	reason := strings.TrimSpace(strings.TrimPrefix(r.hresp.Status, strconv.Itoa(r.hresp.StatusCode)))
+	if reason == "" {
+		reason = http.StatusText(r.hresp.StatusCode)
+	}
 
-	return arr[1]
+	return reason
 }
 
 // IsOk returns true if statusCode is 200.
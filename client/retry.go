@@ -1,8 +1,16 @@
 package client
 
-import "context"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
 
-// RetryFunc 是否需要重试
+// HeaderIdempotencyKey 带有该header的非幂等请求也允许重试
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// RetryFunc 是否需要重试 err为空时根据响应判断
 type RetryFunc func(ctx context.Context, req *Request, resp *Response, retryCount int, err error) (bool, error)
 
 func RetryOnError(ctx context.Context, req *Request, resp *Response, retryCount int, err error) (bool, error) {
@@ -10,7 +18,7 @@ func RetryOnError(ctx context.Context, req *Request, resp *Response, retryCount
 		return false, nil
 	}
 
-	if resp.hresp == nil {
+	if resp == nil || resp.hresp == nil {
 		return false, err
 	}
 
@@ -23,3 +31,47 @@ func RetryOnError(ctx context.Context, req *Request, resp *Response, retryCount
 		return false, nil
 	}
 }
+
+// RetryOnIdempotent retries transport errors and 408/429/5xx gateway errors,
+// only for idempotent methods or requests carrying an Idempotency-Key header.
+// Local errors such as signing or rewinding the body fail again on retry, they are not retried.
+func RetryOnIdempotent(ctx context.Context, req *Request, resp *Response, retryCount int, err error) (bool, error) {
+	if !isIdempotent(req.GetRequest()) {
+		return false, nil
+	}
+
+	if err != nil {
+		return isTransportError(err), nil
+	}
+
+	if resp == nil || resp.hresp == nil {
+		return false, nil
+	}
+
+	switch resp.GetStatusCode() {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// isTransportError reports whether err is returned by sending the request, not by preparing it.
+func isTransportError(err error) bool {
+	var uerr *url.Error
+	return errors.As(err, &uerr)
+}
+
+func isIdempotent(req *http.Request) bool {
+	if req == nil {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return req.Header.Get(HeaderIdempotencyKey) != ""
+}
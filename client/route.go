@@ -0,0 +1,155 @@
+package client
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/yulecd/pp-common/config"
+	"github.com/yulecd/pp-common/plog"
+	"github.com/yulecd/pp-common/server"
+)
+
+const (
+	RouteConfigName = "client_route"
+)
+
+// DefaultRouteHeaders 默认的路由标签header 按顺序取第一个有值的作为路由标签
+var DefaultRouteHeaders = []string{"x-canary", "x-env-tag"}
+
+// RouteConfig 按请求头路由 入口请求带的路由标签透传给下游 并按标签选择服务的 BaseURI
+// 整条调用链都会留在灰度环境
+type RouteConfig struct {
+	Headers  []string                `json:"headers" yaml:"headers"`   // 路由标签header 默认 x-canary x-env-tag
+	Services map[string]ServiceRoute `json:"services" yaml:"services"` // 服务名 -> 路由
+}
+
+// ServiceRoute 一个服务的路由 标签优先匹配 Tags 没有匹配时使用 CanaryBaseURI
+type ServiceRoute struct {
+	CanaryBaseURI string            `json:"canary_base_uri" yaml:"canary_base_uri"` // 带任意路由标签的请求发到这里 为空不路由
+	Tags          map[string]string `json:"tags" yaml:"tags"`                       // 路由标签 -> BaseURI
+}
+
+func (c *RouteConfig) checkConf() {
+	if len(c.Headers) == 0 {
+		c.Headers = DefaultRouteHeaders
+	}
+}
+
+// baseURI returns the base uri of tag, empty if tag is not routed.
+func (s ServiceRoute) baseURI(tag string) string {
+	if tag == "" {
+		return ""
+	}
+	if uri, ok := s.Tags[tag]; ok {
+		return uri
+	}
+	return s.CanaryBaseURI
+}
+
+// Router 按路由标签选择服务节点 配置可以热更新
+type Router struct {
+	mu   sync.RWMutex
+	conf RouteConfig
+}
+
+// NewRouter instances a router with conf.
+func NewRouter(conf RouteConfig) *Router {
+	rt := &Router{}
+	rt.Update(conf)
+	return rt
+}
+
+// Update replaces the routes, it is called when the config changes.
+func (rt *Router) Update(conf RouteConfig) {
+	conf.checkConf()
+
+	rt.mu.Lock()
+	rt.conf = conf
+	rt.mu.Unlock()
+}
+
+// route propagates route headers of the incoming request and switches base uri of the call,
+// route headers set on the call take precedence over the incoming ones.
+// Absolute uri is not routed but the headers are still propagated.
+func (rt *Router) route(r *Request) {
+	rt.mu.RLock()
+	conf := rt.conf
+	rt.mu.RUnlock()
+
+	incoming := server.FromContext(r.ctx)
+	tag := ""
+	for _, key := range conf.Headers {
+		value := headerValue(r.opts.Headers, key)
+		if value == "" && incoming != nil {
+			value = incoming.Header(key)
+			if value != "" {
+				if r.opts.Headers == nil {
+					r.opts.Headers = make(map[string]interface{})
+				}
+				r.opts.Headers[key] = value
+			}
+		}
+		if tag == "" {
+			tag = value
+		}
+	}
+
+	service, ok := conf.Services[r.opts.service]
+	if !ok {
+		return
+	}
+	if uri := service.baseURI(tag); uri != "" {
+		// 和单次指定 BaseURI 一样 不再使用节点池
+		r.opts.BaseURI = uri
+		r.opts.pool = nil
+		if r.Log() != nil {
+			r.Log().Infof("client routed %s by tag %s: %s", r.opts.service, tag, uri)
+		}
+	}
+}
+
+// headerValue returns the string value of key in headers of options, key is case insensitive.
+func headerValue(headers map[string]interface{}, key string) string {
+	key = http.CanonicalHeaderKey(key)
+	for k, v := range headers {
+		if http.CanonicalHeaderKey(k) != key {
+			continue
+		}
+		switch vv := v.(type) {
+		case string:
+			return vv
+		case []string:
+			if len(vv) > 0 {
+				return vv[0]
+			}
+		}
+	}
+	return ""
+}
+
+var (
+	router     *Router
+	routerOnce sync.Once
+)
+
+// getRouter returns the router loaded from RouteConfigName with hot reload,
+// nil if the config does not exist.
+func getRouter() *Router {
+	routerOnce.Do(func() {
+		rt := &Router{}
+		var conf RouteConfig
+		err := config.LoadWithCallback(RouteConfigName, &conf, func(name string) {
+			var conf RouteConfig
+			if err := config.Load(name, &conf); err != nil {
+				plog.Errorf(nil, "client reload route err: %v", err)
+				return
+			}
+			rt.Update(conf)
+		})
+		if err == nil {
+			rt.Update(conf)
+			router = rt
+		}
+	})
+	return router
+}
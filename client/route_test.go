@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yulecd/pp-common/config"
+	"github.com/yulecd/pp-common/server"
+)
+
+// newTagServer responds with its name and the route headers it received.
+func newTagServer(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s|%s", name, r.Header.Get("x-canary"), r.Header.Get("x-env-tag"))
+	}))
+}
+
+// incomingContext returns a context carrying an incoming request with headers.
+func incomingContext(headers map[string]string) context.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	for k, v := range headers {
+		c.Request.Header.Set(k, v)
+	}
+	return server.NewContext(context.Background(), c)
+}
+
+func TestRouter_Route(t *testing.T) {
+	stable, canary, blue := newTagServer("stable"), newTagServer("canary"), newTagServer("blue")
+	defer stable.Close()
+	defer canary.Close()
+	defer blue.Close()
+
+	rt := NewRouter(RouteConfig{Services: map[string]ServiceRoute{
+		"payment": {CanaryBaseURI: canary.URL, Tags: map[string]string{"blue": blue.URL}},
+	}})
+	cli := NewClient(BaseURI(stable.URL), ServiceName("payment"), WithRouter(rt))
+
+	cases := []struct {
+		name    string
+		ctx     context.Context
+		headers map[string]interface{}
+		expect  string
+	}{
+		{"no incoming request", context.Background(), nil, "stable||"},
+		{"no route header", incomingContext(nil), nil, "stable||"},
+		{"canary", incomingContext(map[string]string{"X-Canary": "1"}), nil, "canary|1|"},
+		{"tag", incomingContext(map[string]string{"X-Env-Tag": "blue"}), nil, "blue||blue"},
+		{"first header wins", incomingContext(map[string]string{"X-Canary": "blue", "X-Env-Tag": "other"}), nil, "blue|blue|other"},
+		{"call header wins", incomingContext(map[string]string{"X-Canary": "1"}), map[string]interface{}{"x-canary": "blue"}, "blue|blue|"},
+	}
+	for _, c := range cases {
+		resp, err := cli.WithContext(c.ctx).Get("/", Options{Headers: c.headers})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if body, _ := resp.GetBody(); string(body) != c.expect {
+			t.Fatalf("%s: expect %s, got %s", c.name, c.expect, body)
+		}
+	}
+
+	// 没有路由的服务只透传
+	resp, _ := NewClient(BaseURI(stable.URL), ServiceName("user"), WithRouter(rt)).
+		WithContext(incomingContext(map[string]string{"X-Env-Tag": "blue"})).Get("/")
+	if body, _ := resp.GetBody(); string(body) != "stable||blue" {
+		t.Fatalf("other service should only propagate headers, got %s", body)
+	}
+}
+
+func TestRouter_Reload(t *testing.T) {
+	stable, canary := newTagServer("stable"), newTagServer("canary")
+	defer stable.Close()
+	defer canary.Close()
+
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	write := func(uri string) {
+		content := fmt.Sprintf("client_route:\n  services:\n    payment:\n      canary_base_uri: %s\n", uri)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("")
+	if _, err := config.NewConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	router, routerOnce = nil, sync.Once{}
+
+	cli := NewHttpClientWithConfig(ServiceConfigName, "payment", BaseURI(stable.URL)).
+		WithContext(incomingContext(map[string]string{"X-Canary": "1"}))
+	get := func() string {
+		resp, err := cli.Get("/")
+		if err != nil {
+			return err.Error()
+		}
+		body, _ := resp.GetBody()
+		return string(body)
+	}
+	if body := get(); body != "stable|1|" {
+		t.Fatalf("canary without route should go to stable, got %s", body)
+	}
+
+	write(canary.URL)
+	waitFor(t, func() bool {
+		return get() == "canary|1|"
+	})
+}
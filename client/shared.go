@@ -0,0 +1,142 @@
+package client
+
+import (
+	"io"
+	"reflect"
+	"sync"
+)
+
+// shared 按服务缓存有状态的对象 如连接池 熔断器 重试预算
+// 同一服务多次创建client时复用 配置变化时重建
+// 创建client时在事务里取对象 全部成功才提交 失败时回滚 原来的对象不受影响
+var shared = struct {
+	sync.Mutex
+	m        map[string]*sharedItem
+	tx       *sharedTx
+	replaced []interface{} // NewHttpClientWithConfig 替换的对象 Registry 下次提交后释放
+}{m: make(map[string]*sharedItem)}
+
+type sharedItem struct {
+	conf  interface{}
+	value interface{}
+	kept  bool // NewHttpClientWithConfig 创建的client在用 这些client没有关闭的时机 被替换后也不释放
+}
+
+// sharedTx 一次创建client取到的对象 提交前不影响其他client
+type sharedTx struct {
+	keep   bool
+	staged map[string]*sharedItem
+}
+
+// sharedTxMu 同一时间只有一个事务
+var sharedTxMu sync.Mutex
+
+// beginShared starts a transaction of shared objects, it blocks until the running one ends.
+// keep marks the objects as used by clients which are never released.
+func beginShared(keep bool) *sharedTx {
+	sharedTxMu.Lock()
+
+	tx := &sharedTx{
+		keep:   keep,
+		staged: make(map[string]*sharedItem),
+	}
+	shared.Lock()
+	shared.tx = tx
+	shared.Unlock()
+
+	return tx
+}
+
+// commit makes objects of the transaction shared, it returns the replaced objects which should be released
+// after clients built with the new ones are in use.
+func (tx *sharedTx) commit() []interface{} {
+	defer sharedTxMu.Unlock()
+
+	shared.Lock()
+	defer shared.Unlock()
+
+	var replaced []interface{}
+	for key, item := range tx.staged {
+		if old, ok := shared.m[key]; ok && old != item && !old.kept {
+			replaced = append(replaced, old.value)
+		}
+		if tx.keep {
+			item.kept = true
+		}
+		shared.m[key] = item
+	}
+	shared.tx = nil
+
+	// 之前创建的client可能还在用 留给 Registry 替换client后释放
+	if tx.keep {
+		shared.replaced = append(shared.replaced, replaced...)
+		return nil
+	}
+	replaced = append(replaced, shared.replaced...)
+	shared.replaced = nil
+	return replaced
+}
+
+// rollback discards the transaction, objects built in it are released.
+func (tx *sharedTx) rollback() {
+	defer sharedTxMu.Unlock()
+
+	shared.Lock()
+	var built []interface{}
+	for key, item := range tx.staged {
+		if old, ok := shared.m[key]; !ok || old != item {
+			built = append(built, item.value)
+		}
+	}
+	shared.tx = nil
+	shared.Unlock()
+
+	for _, v := range built {
+		releaseShared(v)
+	}
+}
+
+// getShared returns the object of kind for service, build is called when it does not exist or conf changed.
+// In a transaction the new object is shared after commit, otherwise it is shared at once and the replaced one is kept.
+func getShared(kind, service string, conf interface{}, build func() interface{}) interface{} {
+	key := kind + "/" + service
+
+	shared.Lock()
+	defer shared.Unlock()
+
+	tx := shared.tx
+	var (
+		item *sharedItem
+		ok   bool
+	)
+	if tx != nil {
+		item, ok = tx.staged[key]
+	}
+	if !ok {
+		item, ok = shared.m[key]
+	}
+	if !ok || !reflect.DeepEqual(item.conf, conf) {
+		item = &sharedItem{
+			conf:  conf,
+			value: build(),
+		}
+	}
+
+	if tx != nil {
+		tx.staged[key] = item
+	} else {
+		item.kept = true
+		shared.m[key] = item
+	}
+	return item.value
+}
+
+// releaseShared releases resources of the replaced object, clients still holding it keep working.
+func releaseShared(v interface{}) {
+	switch c := v.(type) {
+	case io.Closer:
+		c.Close()
+	case interface{ CloseIdleConnections() }:
+		c.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// closeCounter counts Close calls.
+type closeCounter struct {
+	closed *int32
+}
+
+func (c *closeCounter) Close() error {
+	atomic.AddInt32(c.closed, 1)
+	return nil
+}
+
+func TestShared_Transaction(t *testing.T) {
+	var closed int32
+	build := func() interface{} {
+		return &closeCounter{closed: &closed}
+	}
+
+	tx := beginShared(false)
+	a := getShared("test", "tx", 1, build)
+	if getShared("test", "tx", 1, build) != a {
+		t.Fatal("same config in a transaction should share the object")
+	}
+	tx.commit()
+
+	// 回滚时新建的对象被释放 原来的对象不受影响
+	tx = beginShared(false)
+	if b := getShared("test", "tx", 2, build); b == a {
+		t.Fatal("changed config should rebuild the object")
+	}
+	tx.rollback()
+	if n := atomic.LoadInt32(&closed); n != 1 {
+		t.Fatalf("object built in rolled back transaction should be released, closed %d", n)
+	}
+	tx = beginShared(false)
+	if getShared("test", "tx", 1, build) != a {
+		t.Fatal("rollback should keep the old object")
+	}
+	tx.commit()
+
+	// NewHttpClientWithConfig 替换的对象留到 Registry 提交后释放 它自己用的对象被替换后也不释放
+	tx = beginShared(true)
+	getShared("test", "tx", 3, build)
+	if replaced := tx.commit(); len(replaced) != 0 {
+		t.Fatalf("kept transaction should not release objects, got %d", len(replaced))
+	}
+	tx = beginShared(false)
+	getShared("test", "tx", 4, build)
+	replaced := tx.commit()
+	if len(replaced) != 1 || replaced[0] != a {
+		t.Fatalf("only the object replaced before should be released, got %v", replaced)
+	}
+}
@@ -0,0 +1,312 @@
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignHMACSHA256 = "hmac-sha256"
+	SignRSASHA256  = "rsa-sha256"
+
+	SignPlaceHeader = "header"
+	SignPlaceQuery  = "query"
+)
+
+// SignConfig 请求签名配置 密钥可以直接写在配置里或者指定文件
+type SignConfig struct {
+	Algorithm      string `json:"algorithm" yaml:"algorithm"`               // hmac-sha256 rsa-sha256 默认 hmac-sha256
+	KeyID          string `json:"key_id" yaml:"key_id"`                     // 商户号或者 app id 为空则不发送
+	Secret         string `json:"secret" yaml:"secret"`                     // hmac 密钥
+	PrivateKey     string `json:"private_key" yaml:"private_key"`           // rsa 私钥 PEM 格式 支持 PKCS1 PKCS8
+	PrivateKeyFile string `json:"private_key_file" yaml:"private_key_file"` // rsa 私钥文件 PrivateKey 为空时使用
+	Encoding       string `json:"encoding" yaml:"encoding"`                 // 签名编码 base64 hex 默认 base64
+	Place          string `json:"place" yaml:"place"`                       // 签名放在 header 或 query 默认 header
+
+	SignatureName string `json:"signature_name" yaml:"signature_name"` // 默认 X-Signature
+	TimestampName string `json:"timestamp_name" yaml:"timestamp_name"` // 默认 X-Timestamp
+	NonceName     string `json:"nonce_name" yaml:"nonce_name"`         // 默认 X-Nonce
+	KeyIDName     string `json:"key_id_name" yaml:"key_id_name"`       // 默认 X-Key-Id
+}
+
+func (c *SignConfig) checkConf() {
+	if c.Algorithm == "" {
+		c.Algorithm = SignHMACSHA256
+	}
+	if c.Encoding == "" {
+		c.Encoding = "base64"
+	}
+	if c.Place == "" {
+		c.Place = SignPlaceHeader
+	}
+	if c.SignatureName == "" {
+		c.SignatureName = "X-Signature"
+	}
+	if c.TimestampName == "" {
+		c.TimestampName = "X-Timestamp"
+	}
+	if c.NonceName == "" {
+		c.NonceName = "X-Nonce"
+	}
+	if c.KeyIDName == "" {
+		c.KeyIDName = "X-Key-Id"
+	}
+}
+
+// SignPayload 参与签名的请求内容
+type SignPayload struct {
+	Method    string
+	Path      string // 转义后的path
+	Query     string // 按key排序后的query
+	BodyHash  string // body 的 sha256 hex 空body也计算
+	Timestamp string // unix 秒
+	Nonce     string
+	KeyID     string
+}
+
+// CanonicalFunc builds the string to be signed.
+type CanonicalFunc func(p *SignPayload) string
+
+// PlaceFunc puts signature and its parameters into the request.
+type PlaceFunc func(req *http.Request, p *SignPayload, signature string)
+
+// SignOption customizes a Signer.
+type SignOption func(s *Signer)
+
+// WithCanonical replaces the default canonical string.
+func WithCanonical(fn CanonicalFunc) SignOption {
+	return func(s *Signer) {
+		s.canonical = fn
+	}
+}
+
+// WithPlace replaces the default header or query placement.
+func WithPlace(fn PlaceFunc) SignOption {
+	return func(s *Signer) {
+		s.place = fn
+	}
+}
+
+// DefaultCanonical joins method, path, sorted query, body hash, timestamp and nonce with "\n".
+func DefaultCanonical(p *SignPayload) string {
+	return strings.Join([]string{p.Method, p.Path, p.Query, p.BodyHash, p.Timestamp, p.Nonce}, "\n")
+}
+
+// Signer 请求签名 作为wrapper使用时在body和query解析之后签名 签名内容和实际发送的一致
+// 每次尝试(包括重试和对冲请求)在选定节点后重新签名 使用新的时间戳和随机数
+type Signer struct {
+	conf      SignConfig
+	secret    []byte
+	key       *rsa.PrivateKey
+	canonical CanonicalFunc
+	place     PlaceFunc
+}
+
+// NewSigner instances a signer, rsa private key is loaded from config or file.
+func NewSigner(conf SignConfig, opts ...SignOption) (*Signer, error) {
+	conf.checkConf()
+
+	s := &Signer{
+		conf:      conf,
+		canonical: DefaultCanonical,
+	}
+	s.place = s.defaultPlace
+
+	switch conf.Algorithm {
+	case SignHMACSHA256:
+		if conf.Secret == "" {
+			return nil, errors.New("sign: empty hmac secret")
+		}
+		s.secret = []byte(conf.Secret)
+	case SignRSASHA256:
+		content := []byte(conf.PrivateKey)
+		if len(content) == 0 && conf.PrivateKeyFile != "" {
+			var err error
+			if content, err = ioutil.ReadFile(conf.PrivateKeyFile); err != nil {
+				return nil, fmt.Errorf("sign: read private key err: %w", err)
+			}
+		}
+		key, err := parseRSAPrivateKey(content)
+		if err != nil {
+			return nil, err
+		}
+		s.key = key
+	default:
+		return nil, fmt.Errorf("sign: unsupported algorithm %q", conf.Algorithm)
+	}
+
+	switch conf.Encoding {
+	case "base64", "hex":
+	default:
+		return nil, fmt.Errorf("sign: unsupported encoding %q", conf.Encoding)
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s, nil
+}
+
+// Wrapper signs every attempt of the request right before it is sent, after the endpoint is chosen,
+// so retries and hedged requests get their own timestamp and nonce.
+func (s *Signer) Wrapper(next Wrapper) Wrapper {
+	return func(ctx context.Context, req *Request) (*Response, error) {
+		req.signer = s
+		return next(ctx, req)
+	}
+}
+
+// Sign computes signature of req and places it, body is read through GetBody so it is not consumed.
+// Signature params placed in query by the previous Sign are removed first.
+func (s *Signer) Sign(req *http.Request) error {
+	bodyHash, err := hashBody(req)
+	if err != nil {
+		return err
+	}
+
+	if s.conf.Place == SignPlaceQuery {
+		q := req.URL.Query()
+		signed := false
+		for _, name := range []string{s.conf.KeyIDName, s.conf.TimestampName, s.conf.NonceName, s.conf.SignatureName} {
+			if _, ok := q[name]; ok {
+				q.Del(name)
+				signed = true
+			}
+		}
+		if signed {
+			req.URL.RawQuery = q.Encode()
+		}
+	}
+
+	p := &SignPayload{
+		Method:    req.Method,
+		Path:      req.URL.EscapedPath(),
+		Query:     req.URL.Query().Encode(),
+		BodyHash:  bodyHash,
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Nonce:     newNonce(),
+		KeyID:     s.conf.KeyID,
+	}
+
+	sig, err := s.sign([]byte(s.canonical(p)))
+	if err != nil {
+		return err
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(sig)
+	if s.conf.Encoding == "hex" {
+		encoded = hex.EncodeToString(sig)
+	}
+	s.place(req, p, encoded)
+
+	return nil
+}
+
+func (s *Signer) sign(content []byte) ([]byte, error) {
+	if s.key != nil {
+		digest := sha256.Sum256(content)
+		return rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	}
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(content)
+	return mac.Sum(nil), nil
+}
+
+func (s *Signer) defaultPlace(req *http.Request, p *SignPayload, signature string) {
+	params := [][2]string{
+		{s.conf.KeyIDName, p.KeyID},
+		{s.conf.TimestampName, p.Timestamp},
+		{s.conf.NonceName, p.Nonce},
+		{s.conf.SignatureName, signature},
+	}
+
+	if s.conf.Place == SignPlaceQuery {
+		q := req.URL.Query()
+		for _, kv := range params {
+			if kv[1] != "" {
+				q.Set(kv[0], kv[1])
+			}
+		}
+		req.URL.RawQuery = q.Encode()
+		return
+	}
+
+	for _, kv := range params {
+		if kv[1] != "" {
+			req.Header.Set(kv[0], kv[1])
+		}
+	}
+}
+
+// hashBody returns sha256 hex of the request body without consuming it.
+func hashBody(req *http.Request) (string, error) {
+	h := sha256.New()
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return "", errors.New("sign: request body can not be read twice")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return "", err
+		}
+
+		// 只能Seek的body和发送的body共用一个reader 读取后需要重置
+		if body, err = req.GetBody(); err != nil {
+			return "", err
+		}
+		req.Body.Close()
+		req.Body = body
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func parseRSAPrivateKey(content []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("sign: invalid rsa private key pem")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("sign: parse rsa private key err: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("sign: private key is not rsa")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// newSignServer rebuilds the canonical string from what it received and verifies it with verify.
+func newSignServer(t *testing.T, verify func(canonical, signature string) bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+
+		q := r.URL.Query()
+		sig := r.Header.Get("X-Signature")
+		ts, nonce := r.Header.Get("X-Timestamp"), r.Header.Get("X-Nonce")
+		if sig == "" {
+			sig, ts, nonce = q.Get("sign"), q.Get("X-Timestamp"), q.Get("X-Nonce")
+			for _, k := range []string{"sign", "X-Timestamp", "X-Nonce", "X-Key-Id"} {
+				q.Del(k)
+			}
+		}
+
+		canonical := DefaultCanonical(&SignPayload{
+			Method:    r.Method,
+			Path:      r.URL.EscapedPath(),
+			Query:     q.Encode(),
+			BodyHash:  hex.EncodeToString(sum[:]),
+			Timestamp: ts,
+			Nonce:     nonce,
+		})
+		if !verify(canonical, sig) {
+			t.Errorf("invalid signature for %q", canonical)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+}
+
+func TestSigner_HMAC(t *testing.T) {
+	secret := "s3cret"
+	ts := newSignServer(t, func(canonical, signature string) bool {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(canonical))
+		return signature == base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	})
+	defer ts.Close()
+
+	signer, err := NewSigner(SignConfig{Secret: secret, KeyID: "m001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := NewClient(BaseURI(ts.URL), Wrap(signer.Wrapper))
+
+	resp, err := cli.Post("/pay/order", Options{
+		Query: map[string]interface{}{"b": "2", "a": "1"},
+		JSON:  map[string]interface{}{"amount": 100},
+	})
+	if err != nil || resp.GetStatusCode() != http.StatusOK {
+		t.Fatalf("signed post failed: %v %d", err, resp.GetStatusCode())
+	}
+	if resp.GetHttpRequest().Header.Get("X-Key-Id") != "m001" {
+		t.Fatal("key id header not set")
+	}
+
+	resp, err = cli.Get("/pay/query?id=1")
+	if err != nil || resp.GetStatusCode() != http.StatusOK {
+		t.Fatalf("signed get failed: %v %d", err, resp.GetStatusCode())
+	}
+
+	// 签名放在query里
+	signer, _ = NewSigner(SignConfig{Secret: secret, Place: SignPlaceQuery, SignatureName: "sign"})
+	resp, err = NewClient(BaseURI(ts.URL), Wrap(signer.Wrapper)).Post("/pay/refund?id=1", Options{
+		FormParams: map[string]interface{}{"amount": "100"},
+	})
+	if err != nil || resp.GetStatusCode() != http.StatusOK {
+		t.Fatalf("query signed post failed: %v %d", err, resp.GetStatusCode())
+	}
+}
+
+func TestSigner_RSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+
+	ts := newSignServer(t, func(canonical, signature string) bool {
+		sig, err := hex.DecodeString(signature)
+		if err != nil {
+			return false
+		}
+		digest := sha256.Sum256([]byte(canonical))
+		return rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig) == nil
+	})
+	defer ts.Close()
+
+	signer, err := NewSigner(SignConfig{Algorithm: SignRSASHA256, PrivateKeyFile: path, Encoding: "hex"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := NewClient(BaseURI(ts.URL), Wrap(signer.Wrapper)).Post("/settle", Options{
+		Multipart: []Part{{Name: "file", FileName: "a.csv", Value: "id,amount"}},
+	})
+	if err != nil || resp.GetStatusCode() != http.StatusOK {
+		t.Fatalf("rsa signed upload failed: %v %d", err, resp.GetStatusCode())
+	}
+}
+
+func TestSigner_Custom(t *testing.T) {
+	signer, err := NewSigner(SignConfig{Secret: "k"},
+		WithCanonical(func(p *SignPayload) string {
+			return p.Method + p.Path
+		}),
+		WithPlace(func(req *http.Request, p *SignPayload, signature string) {
+			req.Header.Set("Authorization", "HMAC "+signature)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/a", nil)
+	if err = signer.Sign(req); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("k"))
+	mac.Write([]byte("GET/a"))
+	if req.Header.Get("Authorization") != "HMAC "+base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("custom signature mismatch: %s", req.Header.Get("Authorization"))
+	}
+
+	if _, err = NewSigner(SignConfig{Algorithm: SignRSASHA256, PrivateKey: "bad"}); err == nil {
+		t.Fatal("invalid rsa key should fail")
+	}
+}
+
+func TestSigner_Retry(t *testing.T) {
+	secret := "s3cret"
+	verified := newSignServer(t, func(canonical, signature string) bool {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(canonical))
+		return signature == base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	})
+	defer verified.Close()
+
+	// 第一次请求失败 重试发到另一个节点
+	var paths, nonces []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		nonces = append(nonces, r.Header.Get("X-Nonce"))
+		verified.Config.Handler.ServeHTTP(w, r)
+		if len(paths) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	pool, _ := NewEndpointPool([]EndpointConfig{{URI: ts.URL + "/a"}, {URI: ts.URL + "/b"}}, LoadBalanceConfig{})
+	defer pool.Close()
+	signer, _ := NewSigner(SignConfig{Secret: secret})
+	cli := NewClient(WithEndpointPool(pool), Wrap(signer.Wrapper), Retries(2), Retry(RetryOnIdempotent), Backoff(ConstantBackoff(0)))
+
+	resp, err := cli.Get("/pay/query")
+	if err != nil || resp.GetStatusCode() != http.StatusOK {
+		t.Fatalf("signed retry failed: %v", err)
+	}
+	if len(paths) != 2 || paths[0] == paths[1] || nonces[0] == nonces[1] {
+		t.Fatalf("every attempt should be signed for its endpoint, paths %v nonces %v", paths, nonces)
+	}
+}
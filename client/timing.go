@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Timing 一次尝试的耗时分解 复用连接时 DNS Connect TLS 为0
+type Timing struct {
+	DNS       time.Duration // dns 解析
+	Connect   time.Duration // tcp 建连
+	TLS       time.Duration // tls 握手
+	FirstByte time.Duration // 从发起尝试到收到响应第一个字节 包含上面三项和服务端处理时间
+	Total     time.Duration // 从发起尝试到响应体读取完成或关闭
+	Reused    bool          // 是否复用了连接池里的连接
+}
+
+// fields returns log fields of t in milliseconds.
+func (t Timing) fields() logrus.Fields {
+	ms := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+	return logrus.Fields{
+		"dns":     ms(t.DNS),
+		"connect": ms(t.Connect),
+		"tls":     ms(t.TLS),
+		"ttfb":    ms(t.FirstByte),
+		"total":   ms(t.Total),
+		"reused":  t.Reused,
+	}
+}
+
+// timingTrace collects httptrace events of one attempt, dialing may still report
+// after the attempt is canceled so fields are guarded by mu.
+type timingTrace struct {
+	mu     sync.Mutex
+	start  time.Time
+	timing Timing
+
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	done         bool
+}
+
+func newTimingTrace() *timingTrace {
+	return &timingTrace{start: time.Now()}
+}
+
+// withContext returns ctx which reports the events of the attempt to t.
+func (t *timingTrace) withContext(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.set(func() { t.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.set(func() { t.timing.DNS = time.Since(t.dnsStart) })
+		},
+		ConnectStart: func(string, string) {
+			t.set(func() {
+				// 多个地址并发建连时 从第一个开始计算
+				if t.connectStart.IsZero() {
+					t.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(string, string, error) {
+			t.set(func() { t.timing.Connect = time.Since(t.connectStart) })
+		},
+		TLSHandshakeStart: func() {
+			t.set(func() { t.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.set(func() { t.timing.TLS = time.Since(t.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.set(func() { t.timing.Reused = info.Reused })
+		},
+		GotFirstResponseByte: func() {
+			t.set(func() { t.timing.FirstByte = time.Since(t.start) })
+		},
+	})
+}
+
+func (t *timingTrace) set(fn func()) {
+	t.mu.Lock()
+	if !t.done {
+		fn()
+	}
+	t.mu.Unlock()
+}
+
+// finish records the total duration, events after it are ignored.
+func (t *timingTrace) finish() {
+	t.mu.Lock()
+	if !t.done {
+		t.done = true
+		t.timing.Total = time.Since(t.start)
+	}
+	t.mu.Unlock()
+}
+
+// snapshot returns the timing collected so far.
+func (t *timingTrace) snapshot() Timing {
+	if t == nil {
+		return Timing{}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing := t.timing
+	if !t.done {
+		timing.Total = time.Since(t.start)
+	}
+	return timing
+}
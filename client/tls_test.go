@@ -0,0 +1,264 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeClientCert writes a self signed client certificate with serial into dir.
+func writeClientCert(t *testing.T, dir string, serial int64) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	// 先写临时文件再重命名 避免读到写了一半的证书
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	for path, block := range map[string]*pem.Block{
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDer},
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+	} {
+		if err = ioutil.WriteFile(path+".tmp", pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Rename(path+".tmp", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return certFile, keyFile
+}
+
+// newMTLSServer requires client certificates and records serial of the last one.
+func newMTLSServer(t *testing.T) (*httptest.Server, string, *int64) {
+	var serial int64
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.StoreInt64(&serial, r.TLS.PeerCertificates[0].SerialNumber.Int64())
+		w.Write([]byte("ok"))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return ts, caFile, &serial
+}
+
+func TestTransport_MutualTLS(t *testing.T) {
+	ts, caFile, serial := newMTLSServer(t)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile := writeClientCert(t, dir, 1)
+	transport := NewTransport(TransportConfig{
+		TLS: &TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: caFile},
+	}).(*tlsTransport)
+	defer transport.Close()
+	cli := NewClient(Transport(transport))
+
+	if _, err := cli.Get(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(serial); n != 1 {
+		t.Fatalf("expect client cert 1, got %d", n)
+	}
+
+	// 证书更新后 新连接使用新证书
+	writeClientCert(t, dir, 2)
+	waitFor(t, func() bool {
+		transport.CloseIdleConnections()
+		_, err := cli.Get(ts.URL)
+		return err == nil && atomic.LoadInt64(serial) == 2
+	})
+}
+
+func TestTransport_ReloadCA(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// 开始信任的是其他证书
+	dir := t.TempDir()
+	caFile, _ := writeClientCert(t, dir, 1)
+	conf := TransportConfig{TLS: &TLSConfig{CAFile: caFile}}
+	transport := NewTransport(conf).(*tlsTransport)
+	cli := NewClient(Transport(transport))
+	if _, err := cli.Get(ts.URL); err == nil {
+		t.Fatal("untrusted server should fail")
+	}
+
+	// 相同的配置共享证书的监听
+	other := NewTransport(conf).(*tlsTransport)
+	if other.loader != transport.loader {
+		t.Fatal("same tls config should share the loader")
+	}
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile+".tmp", ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(caFile+".tmp", caFile); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		_, err := cli.Get(ts.URL)
+		return err == nil
+	})
+
+	// 最后一个transport关闭后停止监听
+	transport.Close()
+	other.Close()
+	tlsLoaders.Lock()
+	_, ok := tlsLoaders.m[transport.loader.key]
+	tlsLoaders.Unlock()
+	if ok || transport.loader.refs != 0 {
+		t.Fatal("loader should be released after all transports are closed")
+	}
+}
+
+func TestTransport_PinnedSPKI(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	sum := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+
+	for _, c := range []struct {
+		pins []string
+		ok   bool
+	}{
+		{[]string{pin}, true},
+		{[]string{"bm90IGEgcGlu"}, false},
+	} {
+		transport := NewTransport(TransportConfig{
+			TLS: &TLSConfig{InsecureSkipVerify: true, PinnedSPKI: c.pins},
+		})
+		_, err := NewClient(Transport(transport)).Get(ts.URL)
+		if (err == nil) != c.ok {
+			t.Fatalf("pins %v expect ok %v, got %v", c.pins, c.ok, err)
+		}
+	}
+
+	// 不信任的证书和错误的配置都不能回退到不校验
+	for _, conf := range []TLSConfig{
+		{},
+		{CAFile: filepath.Join(t.TempDir(), "missing.crt")},
+		{MinVersion: "1.4"},
+	} {
+		transport := NewTransport(TransportConfig{TLS: &conf})
+		if _, err := NewClient(Transport(transport)).Get(ts.URL); err == nil {
+			t.Fatalf("config %+v should fail", conf)
+		}
+	}
+}
+
+func TestTransport_VerifyHost(t *testing.T) {
+	// 证书只对 example.com 有效 不包含 127.0.0.1
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		DNSNames:              []string{"example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(ts.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+
+	// 公钥匹配也不能跳过 host 校验
+	for _, conf := range []TLSConfig{
+		{CAFile: caFile},
+		{CAFile: caFile, PinnedSPKI: []string{pin}},
+	} {
+		transport := NewTransport(TransportConfig{TLS: &conf})
+		if _, err = NewClient(Transport(transport)).Get(ts.URL); err == nil {
+			t.Fatalf("config %+v: ip host not in certificate should fail", conf)
+		}
+	}
+	transport := NewTransport(TransportConfig{TLS: &TLSConfig{CAFile: caFile, ServerName: "example.com", PinnedSPKI: []string{pin}}})
+	if _, err = NewClient(Transport(transport)).Get(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransport_Proxy(t *testing.T) {
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 代理收到的是绝对地址
+		if r.URL.Host == "upstream.internal" {
+			atomic.AddInt32(&proxied, 1)
+		}
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	transport := NewTransport(TransportConfig{
+		Proxy: &ProxyConfig{URL: proxy.URL, NoProxy: []string{".example.com"}},
+	}).(*http.Transport)
+	cli := NewClient(Transport(transport))
+
+	resp, err := cli.Get("http://upstream.internal/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := resp.GetBody(); string(body) != "proxied" || atomic.LoadInt32(&proxied) != 1 {
+		t.Fatalf("request should go through proxy: %s", body)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://api.example.com/", nil)
+	if u, _ := transport.Proxy(req); u != nil {
+		t.Fatalf("no_proxy host should not use proxy, got %v", u)
+	}
+
+	if _, err = NewClient(WithTransportConfig(TransportConfig{Proxy: &ProxyConfig{URL: "ftp://1.1.1.1"}})).Get("http://upstream.internal/api"); err == nil {
+		t.Fatal("invalid proxy should fail")
+	}
+}
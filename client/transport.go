@@ -0,0 +1,142 @@
+package client
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransportConfig 连接池配置 同一个配置的client共享同一个transport
+type TransportConfig struct {
+	MaxIdleConns          int           `json:"max_idle_conns" yaml:"max_idle_conns"`                   // 总空闲连接数
+	MaxIdleConnsPerHost   int           `json:"max_idle_conns_per_host" yaml:"max_idle_conns_per_host"` // 每个host空闲连接数
+	MaxConnsPerHost       int           `json:"max_conns_per_host" yaml:"max_conns_per_host"`           // 每个host最大连接数 0不限制
+	IdleConnTimeout       time.Duration `json:"idle_conn_timeout" yaml:"idle_conn_timeout"`             // 空闲连接存活时间
+	DialTimeout           time.Duration `json:"dial_timeout" yaml:"dial_timeout"`                       // 建立连接超时时间
+	KeepAlive             time.Duration `json:"keep_alive" yaml:"keep_alive"`                           // tcp keep-alive 间隔
+	TLSHandshakeTimeout   time.Duration `json:"tls_handshake_timeout" yaml:"tls_handshake_timeout"`     // tls握手超时时间
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout" yaml:"response_header_timeout"` // 等待响应头超时时间 0不限制
+	DisableKeepAlives     bool          `json:"disable_keep_alives" yaml:"disable_keep_alives"`         // 关闭长连接
+
+	TLS   *TLSConfig   `json:"tls" yaml:"tls"`     // 证书配置 不配置使用系统根证书
+	Proxy *ProxyConfig `json:"proxy" yaml:"proxy"` // 代理配置 不配置使用环境变量
+}
+
+func (c *TransportConfig) checkConf() {
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = 200
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = 32
+	}
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = 90 * time.Second
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = 3 * time.Second
+	}
+	if c.KeepAlive <= 0 {
+		c.KeepAlive = 30 * time.Second
+	}
+	if c.TLSHandshakeTimeout <= 0 {
+		c.TLSHandshakeTimeout = 5 * time.Second
+	}
+}
+
+// DefaultTransport is shared by all clients without their own transport config.
+var DefaultTransport http.RoundTripper = NewTransport(TransportConfig{})
+
+// NewTransport instances a tuned transport, zero fields of conf use defaults.
+// When conf.TLS is set, the returned transport rebuilds its connection pool after the CA file changes,
+// and it is an io.Closer which stops watching certificate files.
+func NewTransport(conf TransportConfig) http.RoundTripper {
+	conf.checkConf()
+	if conf.TLS == nil {
+		return newHTTPTransport(conf, nil)
+	}
+
+	t := &tlsTransport{
+		conf:   conf,
+		loader: acquireTLSLoader(*conf.TLS),
+	}
+	t.cur.Store(newHTTPTransport(conf, t.loader.tlsConfig()))
+	t.loader.subscribe(t, true)
+
+	return t
+}
+
+func newHTTPTransport(conf TransportConfig, tlsConfig *tls.Config) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   conf.DialTimeout,
+		KeepAlive: conf.KeepAlive,
+	}
+
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          conf.MaxIdleConns,
+		MaxIdleConnsPerHost:   conf.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       conf.MaxConnsPerHost,
+		IdleConnTimeout:       conf.IdleConnTimeout,
+		TLSHandshakeTimeout:   conf.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: conf.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableKeepAlives:     conf.DisableKeepAlives,
+		TLSClientConfig:       tlsConfig,
+	}
+
+	if conf.Proxy != nil {
+		t.Proxy = proxyFunc(*conf.Proxy)
+	}
+
+	return t
+}
+
+// tlsTransport CA文件变化时用新的根证书重建连接池 旧连接池的空闲连接立即关闭 使用中的连接空闲超时后关闭
+type tlsTransport struct {
+	conf   TransportConfig
+	loader *tlsLoader
+	cur    atomic.Value // *http.Transport
+	once   sync.Once
+}
+
+func (t *tlsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.transport().RoundTrip(req)
+}
+
+func (t *tlsTransport) transport() *http.Transport {
+	return t.cur.Load().(*http.Transport)
+}
+
+// reload replaces the connection pool with one using the current roots.
+func (t *tlsTransport) reload() {
+	old := t.transport()
+	t.cur.Store(newHTTPTransport(t.conf, t.loader.tlsConfig()))
+	old.CloseIdleConnections()
+}
+
+// CloseIdleConnections closes idle connections of the current connection pool.
+func (t *tlsTransport) CloseIdleConnections() {
+	t.transport().CloseIdleConnections()
+}
+
+// Close closes idle connections and stops watching certificate files if no other transport shares them.
+func (t *tlsTransport) Close() error {
+	t.once.Do(func() {
+		t.loader.subscribe(t, false)
+		t.loader.release()
+	})
+	t.CloseIdleConnections()
+	return nil
+}
+
+// getServiceTransport returns the shared transport of service, it will be rebuilt when conf changed.
+func getServiceTransport(service string, conf TransportConfig) http.RoundTripper {
+	return getShared("transport", service, conf, func() interface{} {
+		return NewTransport(conf)
+	}).(http.RoundTripper)
+}
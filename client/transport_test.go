@@ -0,0 +1,85 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// newConnCountServer returns a test server and a counter of new connections it accepted.
+func newConnCountServer() (*httptest.Server, *int64) {
+	var conns int64
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	ts.Start()
+	return ts, &conns
+}
+
+func TestTransportReuse(t *testing.T) {
+	ts, conns := newConnCountServer()
+	defer ts.Close()
+
+	cli := NewClient(WithTransportConfig(TransportConfig{}))
+	for i := 0; i < 20; i++ {
+		resp, err := cli.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := resp.GetBody(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := atomic.LoadInt64(conns); n != 1 {
+		t.Fatalf("expect 1 connection, got %d", n)
+	}
+}
+
+func TestServiceTransportShared(t *testing.T) {
+	conf := TransportConfig{MaxIdleConnsPerHost: 8}
+	a := getServiceTransport("test-shared", conf)
+	b := getServiceTransport("test-shared", conf)
+	if a != b {
+		t.Fatal("same service and config should share transport")
+	}
+
+	conf.MaxIdleConnsPerHost = 16
+	if c := getServiceTransport("test-shared", conf); c == a {
+		t.Fatal("changed config should rebuild transport")
+	}
+}
+
+func BenchmarkRequest_Get(b *testing.B) {
+	bench := func(b *testing.B, cli *Request) {
+		ts, conns := newConnCountServer()
+		defer ts.Close()
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				resp, err := cli.Get(ts.URL)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				resp.GetBody()
+			}
+		})
+		b.ReportMetric(float64(atomic.LoadInt64(conns)), "conns")
+	}
+
+	b.Run("shared", func(b *testing.B) {
+		bench(b, NewClient(WithTransportConfig(TransportConfig{})))
+	})
+	b.Run("no-keepalive", func(b *testing.B) {
+		bench(b, NewClient(WithTransportConfig(TransportConfig{DisableKeepAlives: true})))
+	})
+}
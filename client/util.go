@@ -2,7 +2,10 @@ package client
 
 import (
 	"fmt"
+	"math/rand"
 	urlpkg "net/url"
+	"sync"
+	"time"
 )
 
 func IsValidHttpUrl(uri string) bool {
@@ -23,3 +26,29 @@ func IsValidHttpUrl(uri string) bool {
 
 	return true
 }
+
+// rnd 独立种子的随机源 避免多个实例重试时间同步
+var rnd = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// randDuration returns a random duration in [min, max).
+func randDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	rnd.Lock()
+	defer rnd.Unlock()
+	return min + time.Duration(rnd.Int63n(int64(max-min)))
+}
+
+// randInt63n returns a random number in [0, n).
+func randInt63n(n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	rnd.Lock()
+	defer rnd.Unlock()
+	return rnd.Int63n(n)
+}
@@ -19,7 +19,6 @@ const (
 
 type Callback func(name string)
 
-var callbackTable map[string][]Callback
 var callbackTableMutex sync.Mutex
 
 // Reader ConfigReader reads config from any source
@@ -37,7 +36,17 @@ type Config struct {
 	m          sync.Mutex
 }
 
-var config *Config
+var (
+	config   *Config
+	configMu sync.RWMutex // 重新创建配置时保护 config 监听文件的goroutine会同时读取
+)
+
+// current returns the config created by the last New.
+func current() *Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
 
 // Load is used for loading config with name to its own struct
 // for example mysql component will call config.Load("mysql", configObjPointer) to load config to a config object
@@ -59,6 +68,7 @@ var config *Config
 // Load("mysql", &mysqlConfig)
 func Load(name string, configObj interface{}) error {
 	// 检查初始化
+	config := current()
 	if config == nil {
 		return fmt.Errorf("config module have not been inited lock")
 	}
@@ -94,6 +104,7 @@ func LoadWithCallback(name string, configObj interface{}, cb Callback) error {
 		return err
 	}
 
+	config := current()
 	callbackTableMutex.Lock()
 	defer callbackTableMutex.Unlock()
 
@@ -105,23 +116,30 @@ func LoadWithCallback(name string, configObj interface{}, cb Callback) error {
 		config.notifyList[name] = make([]Callback, 0)
 	}
 
-	config.notifyList[name] = append(callbackTable[name], cb)
+	config.notifyList[name] = append(config.notifyList[name], cb)
 	return nil
 }
 
+// notifyCallback calls callbacks of names, all callbacks if names is empty.
+// Callbacks are called after the lock is released, so that they can register new callbacks.
 func notifyCallback(names ...string) {
+	config := current()
 	callbackTableMutex.Lock()
-	defer callbackTableMutex.Unlock()
+	notifyList := make(map[string][]Callback)
 	if len(names) > 0 {
 		for _, name := range names {
 			if callback, ok := config.notifyList[name]; ok {
-				notify(callback, name)
+				notifyList[name] = append([]Callback(nil), callback...)
 			}
 		}
-		return
+	} else {
+		for name, cbArr := range config.notifyList {
+			notifyList[name] = append([]Callback(nil), cbArr...)
+		}
 	}
+	callbackTableMutex.Unlock()
 
-	for name, cbArr := range config.notifyList {
+	for name, cbArr := range notifyList {
 		notify(cbArr, name)
 	}
 }
@@ -146,6 +164,7 @@ func New(opt ...Option) (*Config, error) {
 		o(opts)
 	}
 
+	configMu.Lock()
 	config = &Config{
 		data:       nil,
 		c:          nil,
@@ -162,14 +181,15 @@ func New(opt ...Option) (*Config, error) {
 		config.c = make(map[string]interface{})
 		config.data = &bytes.Buffer{}
 	}
+	configMu.Unlock()
+
 	if err := config.reader.Init(); err != nil {
 		return nil, err
 	}
-	go func() {
-		if err := config.reader.Watch(); err != nil {
-			log.Println(err)
-		}
-	}()
+	// 监听开始后才返回 之后的修改都能通知到
+	if err := config.reader.Watch(); err != nil {
+		log.Println(err)
+	}
 	return config, nil
 }
 
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadWithCallback(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "app.yaml")
+	if err := ioutil.WriteFile(fileName, []byte("app:\n  http_port: 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewConfig(fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	var conf map[string]int
+	calls := make(chan string, 8)
+	for _, id := range []string{"a", "b"} {
+		id := id
+		if err := LoadWithCallback("app", &conf, func(name string) {
+			calls <- id + ":" + name
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conf["http_port"] != 80 {
+		t.Fatalf("unexpected config %v", conf)
+	}
+
+	// 同一个配置的所有回调都要通知
+	notifyCallback("app")
+	if got := receive(calls, 2); len(got) != 2 || got[0] != "a:app" || got[1] != "b:app" {
+		t.Fatalf("every callback should be notified, got %v", got)
+	}
+
+	// 回调里可以注册新的回调
+	if err := LoadWithCallback("app", &conf, func(name string) {
+		LoadWithCallback(name, &conf, func(string) {})
+		calls <- "c:" + name
+	}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		notifyCallback("app")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("register callback in callback should not deadlock")
+	}
+	if got := receive(calls, 3); len(got) != 3 {
+		t.Fatalf("expect 3 callbacks, got %v", got)
+	}
+}
+
+// receive reads n values from ch, it returns early if no value comes in time.
+func receive(ch chan string, n int) []string {
+	got := make([]string, 0, n)
+	for len(got) < n {
+		select {
+		case v := <-ch:
+			got = append(got, v)
+		case <-time.After(time.Second):
+			return got
+		}
+	}
+	return got
+}
@@ -33,6 +33,7 @@ func NewFileReader(env string) Reader {
 }
 
 func (r *ReaderFile) Init() error {
+	config := current()
 	config.m.Lock()
 	defer config.m.Unlock()
 	content, err := ioutil.ReadFile(r.filePath)
@@ -63,7 +64,7 @@ func (r *ReaderFile) Watch() error {
 				if event.Op&fsnotify.Write == fsnotify.Write {
 					err := r.Init()
 					if err != nil {
-						log.Println("file=%s load err=%s", r.filePath, err.Error())
+						log.Printf("file=%s load err=%s", r.filePath, err.Error())
 						continue
 					}
 
@@ -74,7 +75,7 @@ func (r *ReaderFile) Watch() error {
 					return
 				}
 
-				log.Println("config watcher err=%s", err.Error())
+				log.Printf("config watcher err=%s", err.Error())
 			}
 		}
 	}()
@@ -156,6 +156,8 @@ func ChangeTimeToUTC() {
 }
 
 // DownloadFile 下载文件 存在会覆盖
+//
+// Deprecated: 没有超时 重试和续传 使用 client.Download
 func DownloadFile(url string, localPath string, fb func(length, downLen int64)) error {
 	var (
 		fsize   int64
@@ -173,7 +175,7 @@ func DownloadFile(url string, localPath string, fb func(length, downLen int64))
 	}
 
 	// 读取服务器返回的文件大小
-	fsize, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 32)
+	fsize, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
 	if err != nil {
 		fmt.Println(err)
 	}